@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// loginWindow is how long a sign in link can be used
+const loginWindow = 15 * time.Minute
+
+// libraryPurchase is a purchase shown in the library of a customer, with the
+// episodes released since it was bought
+type libraryPurchase struct {
+	Purchase    *Purchase
+	Production  *Production
+	Token       string
+	CanDownload bool
+	HasInvoice  bool
+	NewEpisodes []*Episode
+}
+
+func hashLoginToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendLoginLink emails a one-time sign in link to a customer
+func sendLoginLink(email string) error {
+	token := randomToken(32)
+	if err := insertLoginToken(hashLoginToken(token), email, time.Now().Add(loginWindow)); err != nil {
+		return err
+	}
+
+	link := "https://focuscentric.com/login/confirm?token=" + url.QueryEscape(token)
+	return sendEmail(email, loginEmail{Name: email, Link: link})
+}
+
+// customerEmail returns the email of the signed in customer, or an empty
+// string
+func customerEmail(r *http.Request) string {
+	if s := currentSession(r); s != nil {
+		return s.Email
+	}
+	return ""
+}
+
+// getLibrary returns the purchases of a customer with their download and
+// invoice links
+func getLibrary(email string) ([]*libraryPurchase, error) {
+	purchases, err := GetPurchases(email)
+	if err != nil {
+		return nil, err
+	}
+
+	productions := make(map[int]*Production)
+	var library []*libraryPurchase
+	for _, p := range purchases {
+		prod, ok := productions[p.ProductionID]
+		if !ok {
+			prod, err = GetProduction(p.ProductionID, "")
+			if err != nil {
+				return nil, err
+			}
+			productions[p.ProductionID] = prod
+		}
+
+		item := &libraryPurchase{
+			Purchase:   p,
+			Production: prod,
+			Token:      purchaseToken(email, p.ProductionID, p.ChargeID),
+			// gifts and team licenses are downloaded by their recipients
+			CanDownload: p.Seats == 1 && !p.IsGift && p.Preorder != preorderPending && (p.Amount.IsZero() || p.Refunded.Amount < p.Amount.Amount),
+			HasInvoice:  !p.Amount.IsZero(),
+		}
+		if item.CanDownload {
+			item.NewEpisodes, err = getEpisodesSince(p.ProductionID, p.PurchasedDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+		library = append(library, item)
+	}
+	return library, nil
+}
+
+// loginHandler emails a sign in link, the same message is shown whether the
+// email has purchases or not
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Connexion", LatestEpisodes: latestEpisodes[0:3]}
+
+	if r.Method == http.MethodPost {
+		email := strings.TrimSpace(r.FormValue("email"))
+		if !strings.Contains(email, "@") || len(email) > 250 {
+			d.Message = "Cette adresse courriel n'est pas valide."
+		} else if !allowEmailRequest(r, "login", email) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			d.Message = "Plusieurs liens de connexion ont été demandés récemment, veuillez réessayer plus tard."
+		} else if err := sendLoginLink(email); err != nil {
+			log.Printf("error on loginHandler: %s", err)
+			d.Message = "Le lien de connexion n'a pu être envoyé, veuillez réessayer plus tard."
+		} else {
+			d.Message = "Un lien de connexion vous a été envoyé par courriel, il est valide pendant 15 minutes."
+		}
+	} else if len(customerEmail(r)) > 0 {
+		http.Redirect(w, r, "/library", http.StatusSeeOther)
+		return
+	}
+
+	if err := render(w, r, "login.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+// loginConfirmHandler signs in with the link of the email. The link shows a
+// button posting the token, so mail scanners opening it do not use it up
+func loginConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if r.Method != http.MethodPost {
+		d := &pageData{Title: "Connexion", LatestEpisodes: latestEpisodes[0:3], Token: token}
+		if err := render(w, r, "login-confirm.html", d); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	email, err := "", errors.New("empty token")
+	if len(token) > 0 {
+		email, err = useLoginToken(hashLoginToken(token))
+	}
+	if err != nil {
+		log.Printf("error on loginConfirmHandler: %s", err)
+		d := &pageData{Title: "Connexion", LatestEpisodes: latestEpisodes[0:3]}
+		d.Message = "Ce lien de connexion n'est pas valide ou est expiré, demandez-en un nouveau."
+		if err := render(w, r, "login.html", d); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	if err := signIn(w, r, email); err != nil {
+		log.Printf("error on loginConfirmHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+	if err := recordLogin(email); err != nil {
+		log.Printf("unable to record the login of %s: %s", email, err)
+	}
+	http.Redirect(w, r, "/library", http.StatusSeeOther)
+}
+
+// libraryHandler lists the purchases of the signed in customer
+func libraryHandler(w http.ResponseWriter, r *http.Request) {
+	email := customerEmail(r)
+	if len(email) == 0 {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	library, err := getLibrary(email)
+	if err != nil {
+		log.Printf("error on libraryHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	d := &pageData{Title: "Ma bibliothèque", LatestEpisodes: latestEpisodes[0:3], Customer: email, Purchases: library}
+	if err := render(w, r, "library.html", d); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// journal accounts used in the QuickBooks export, the bank account is
+// suffixed with the currency of the deposit
+const (
+	accountBank     = "Stripe"
+	accountSales    = "Ventes de formations"
+	accountRefunds  = "Remboursements"
+	accountFees     = "Frais de paiement"
+	accountGST      = "TPS à payer"
+	accountHST      = "TVH à payer"
+	accountPST      = "TVP à payer"
+	accountQST      = "TVQ à payer"
+	accountingMonth = "2006-01"
+)
+
+// salesEntry is a purchase in the accounting export, amounts are in cents of
+// its currency. Refund entries only have the Refunded amount, taxes included,
+// and the RefundedTaxes it gives back, they are dated the day of the refund
+type salesEntry struct {
+	Date          time.Time
+	OrderID       string
+	ChargeID      string
+	Email         string
+	ProductionID  int
+	Title         string
+	Currency      string
+	ListPrice     int
+	Promotion     string
+	Subtotal      int
+	Taxes         Taxes
+	Amount        int
+	Refunded      int
+	RefundedTaxes Taxes
+	Fees          int
+	Refund        bool
+}
+
+// Discount returns the amount taken off the list price
+func (e *salesEntry) Discount() int {
+	if e.ListPrice > e.Subtotal {
+		return e.ListPrice - e.Subtotal
+	}
+	return 0
+}
+
+// refundedTaxes returns the taxes given back by a refund, in proportion of
+// the amount of the purchase refunded
+func refundedTaxes(t Taxes, amount, refunded int) Taxes {
+	if amount == 0 {
+		return Taxes{Country: t.Country, Province: t.Province}
+	}
+	t.GST = t.GST * refunded / amount
+	t.HST = t.HST * refunded / amount
+	t.PST = t.PST * refunded / amount
+	t.QST = t.QST * refunded / amount
+	return t
+}
+
+// Net returns what we keep of the purchase once refunds and fees are paid
+func (e *salesEntry) Net() int {
+	return e.Amount - e.Refunded - e.Fees
+}
+
+// salesTotal sums the entries of a month or a production in a currency,
+// RefundedTaxes is the part of Refunded given back as taxes
+type salesTotal struct {
+	Key           string
+	Currency      string
+	Count         int
+	Discount      int
+	Subtotal      int
+	Taxes         Taxes
+	Amount        int
+	Refunded      int
+	RefundedTaxes Taxes
+	Fees          int
+}
+
+// Net returns the total kept once refunds and fees are paid
+func (t *salesTotal) Net() int {
+	return t.Amount - t.Refunded - t.Fees
+}
+
+// salesTotals sums the entries grouped by key and currency
+func salesTotals(entries []*salesEntry, key func(*salesEntry) string) []*salesTotal {
+	byKey := make(map[string]*salesTotal)
+	var totals []*salesTotal
+	for _, e := range entries {
+		k := key(e) + "|" + e.Currency
+		t, ok := byKey[k]
+		if !ok {
+			t = &salesTotal{Key: key(e), Currency: e.Currency}
+			byKey[k] = t
+			totals = append(totals, t)
+		}
+
+		if !e.Refund {
+			t.Count++
+		}
+		t.Discount += e.Discount()
+		t.Subtotal += e.Subtotal
+		t.Taxes.GST += e.Taxes.GST
+		t.Taxes.HST += e.Taxes.HST
+		t.Taxes.PST += e.Taxes.PST
+		t.Taxes.QST += e.Taxes.QST
+		t.Amount += e.Amount
+		t.Refunded += e.Refunded
+		t.RefundedTaxes.GST += e.RefundedTaxes.GST
+		t.RefundedTaxes.HST += e.RefundedTaxes.HST
+		t.RefundedTaxes.PST += e.RefundedTaxes.PST
+		t.RefundedTaxes.QST += e.RefundedTaxes.QST
+		t.Fees += e.Fees
+	}
+
+	sort.SliceStable(totals, func(i, j int) bool {
+		if totals[i].Key != totals[j].Key {
+			return totals[i].Key < totals[j].Key
+		}
+		return totals[i].Currency < totals[j].Currency
+	})
+	return totals
+}
+
+func monthKey(e *salesEntry) string {
+	return e.Date.Format(accountingMonth)
+}
+
+func productionKey(e *salesEntry) string {
+	return e.Title
+}
+
+// parseAccountingPeriod reads the from and to dates of an export, both
+// included, the previous month by default
+func parseAccountingPeriod(from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 1, 0)
+
+	if len(from) > 0 {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return start, end, err
+		}
+		start = t
+	}
+	if len(to) > 0 {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return start, end, err
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+
+	if !start.Before(end) {
+		return start, end, fmt.Errorf("from must be before to")
+	}
+	return start, end, nil
+}
+
+// writeSalesCSV writes the purchases followed by their totals per month and
+// per production, refunds are split between the sale and its taxes
+func writeSalesCSV(w io.Writer, entries []*salesEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Date", "Commande", "Paiement", "Courriel", "Formation", "Devise", "Prix courant", "Rabais", "Promotion", "Sous-total", "TPS", "TVH", "TVP", "TVQ", "Total", "Remboursé", "Taxes remboursées", "Frais", "Net"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Date.Format("2006-01-02"),
+			e.OrderID,
+			e.ChargeID,
+			e.Email,
+			e.Title,
+			e.Currency,
+			formatDecimal(e.ListPrice, 2),
+			formatDecimal(e.Discount(), 2),
+			e.Promotion,
+			formatDecimal(e.Subtotal, 2),
+			formatDecimal(e.Taxes.GST, 2),
+			formatDecimal(e.Taxes.HST, 2),
+			formatDecimal(e.Taxes.PST, 2),
+			formatDecimal(e.Taxes.QST, 2),
+			formatDecimal(e.Amount, 2),
+			formatDecimal(e.Refunded-e.RefundedTaxes.Total(), 2),
+			formatDecimal(e.RefundedTaxes.Total(), 2),
+			formatDecimal(e.Fees, 2),
+			formatDecimal(e.Net(), 2),
+		})
+	}
+
+	writeTotals := func(title string, totals []*salesTotal) {
+		cw.Write(nil)
+		cw.Write([]string{title, "Devise", "Ventes", "Rabais", "Sous-total", "TPS", "TVH", "TVP", "TVQ", "Total", "Remboursé", "Taxes remboursées", "Frais", "Net"})
+		for _, t := range totals {
+			cw.Write([]string{
+				t.Key,
+				t.Currency,
+				strconv.Itoa(t.Count),
+				formatDecimal(t.Discount, 2),
+				formatDecimal(t.Subtotal, 2),
+				formatDecimal(t.Taxes.GST, 2),
+				formatDecimal(t.Taxes.HST, 2),
+				formatDecimal(t.Taxes.PST, 2),
+				formatDecimal(t.Taxes.QST, 2),
+				formatDecimal(t.Amount, 2),
+				formatDecimal(t.Refunded-t.RefundedTaxes.Total(), 2),
+				formatDecimal(t.RefundedTaxes.Total(), 2),
+				formatDecimal(t.Fees, 2),
+				formatDecimal(t.Net(), 2),
+			})
+		}
+	}
+	writeTotals("Mois", salesTotals(entries, monthKey))
+	writeTotals("Formation", salesTotals(entries, productionKey))
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeSalesIIF writes a QuickBooks IIF general journal with one balanced
+// entry per month, production and currency, dated the last day of the month.
+// The taxes of the refunds are taken off the taxes payable
+func writeSalesIIF(w io.Writer, entries []*salesEntry) error {
+	byMonth := make(map[string][]*salesEntry)
+	var months []string
+	for _, e := range entries {
+		m := monthKey(e)
+		if _, ok := byMonth[m]; !ok {
+			months = append(months, m)
+		}
+		byMonth[m] = append(byMonth[m], e)
+	}
+	sort.Strings(months)
+
+	fmt.Fprint(w, "!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO\r\n")
+	fmt.Fprint(w, "!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tNAME\tAMOUNT\tDOCNUM\tMEMO\r\n")
+	fmt.Fprint(w, "!ENDTRNS\r\n")
+
+	for _, m := range months {
+		month, _ := time.ParseInLocation(accountingMonth, m, time.Local)
+		date := month.AddDate(0, 1, -1).Format("01/02/2006")
+
+		for n, t := range salesTotals(byMonth[m], productionKey) {
+			doc := fmt.Sprintf("%s-%d", m, n+1)
+			memo := fmt.Sprintf("%s (%s, %d ventes)", t.Key, t.Currency, t.Count)
+			taxes := t.Taxes.Total()
+
+			fmt.Fprintf(w, "TRNS\t\tGENERAL JOURNAL\t%s\t%s %s\t\t%s\t%s\t%s\r\n", date, accountBank, t.Currency, formatDecimal(t.Net(), 2), doc, memo)
+			splits := []struct {
+				account string
+				amount  int
+			}{
+				{accountFees, t.Fees},
+				{accountRefunds, t.Refunded - t.RefundedTaxes.Total()},
+				{accountSales, -(t.Amount - taxes)},
+				{accountGST, t.RefundedTaxes.GST - t.Taxes.GST},
+				{accountHST, t.RefundedTaxes.HST - t.Taxes.HST},
+				{accountPST, t.RefundedTaxes.PST - t.Taxes.PST},
+				{accountQST, t.RefundedTaxes.QST - t.Taxes.QST},
+			}
+			for _, s := range splits {
+				if s.amount == 0 {
+					continue
+				}
+				fmt.Fprintf(w, "SPL\t\tGENERAL JOURNAL\t%s\t%s\t\t%s\t%s\t%s\r\n", date, s.account, formatDecimal(s.amount, 2), doc, memo)
+			}
+			if _, err := fmt.Fprint(w, "ENDTRNS\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accountingEntries returns purchases and refunds over two months in two
+// currencies, with taxes, fees and a refund made the month after its sale
+func accountingEntries() []*salesEntry {
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 10, 0, 0, 0, time.Local) }
+	return []*salesEntry{
+		{Date: day(3, 4), Title: "Apprendre Go", Currency: "CAD", ListPrice: 4900, Subtotal: 4900,
+			Taxes: Taxes{GST: 245, QST: 489}, Amount: 5634, Fees: 193},
+		{Date: day(3, 9), Title: "Apprendre Go", Currency: "CAD", ListPrice: 4900, Subtotal: 3900,
+			Taxes: Taxes{HST: 507}, Amount: 4407, Fees: 158},
+		{Date: day(3, 12), Title: "Docker", Currency: "USD", ListPrice: 2900, Subtotal: 2900, Amount: 2900, Fees: 114},
+		{Date: day(4, 2), Title: "Apprendre Go", Currency: "CAD", Refunded: 5634,
+			RefundedTaxes: Taxes{GST: 245, QST: 489}, Refund: true},
+		{Date: day(4, 20), Title: "Docker", Currency: "CAD", ListPrice: 2900, Subtotal: 2900,
+			Taxes: Taxes{GST: 145, PST: 203}, Amount: 3248, Fees: 124},
+	}
+}
+
+func TestSalesTotals(t *testing.T) {
+	totals := salesTotals(accountingEntries(), monthKey)
+	if len(totals) != 3 {
+		t.Fatalf("got %d totals, want 2024-03 CAD, 2024-03 USD and 2024-04 CAD", len(totals))
+	}
+
+	march := totals[0]
+	if march.Key != "2024-03" || march.Currency != "CAD" || march.Count != 2 || march.Discount != 1000 ||
+		march.Amount != 10041 || march.Refunded != 0 || march.Fees != 351 || march.Net() != 9690 {
+		t.Errorf("2024-03 CAD = %+v", march)
+	}
+
+	// the refund is booked the month it was made and is not a sale
+	april := totals[2]
+	if april.Key != "2024-04" || april.Count != 1 || april.Refunded != 5634 || april.Net() != 3248-5634-124 {
+		t.Errorf("2024-04 CAD = %+v", april)
+	}
+}
+
+func TestWriteSalesIIFBalanced(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeSalesIIF(&b, accountingEntries()); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions := 0
+	balance := 0
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\r\n") {
+		fields := strings.Split(line, "\t")
+		switch fields[0] {
+		case "TRNS", "SPL":
+			amount, err := parseAmount(strings.TrimPrefix(fields[6], "-"))
+			if err != nil {
+				t.Fatalf("invalid amount in %q: %s", line, err)
+			}
+			if strings.HasPrefix(fields[6], "-") {
+				amount = -amount
+			}
+			balance += amount
+			if fields[0] == "TRNS" {
+				transactions++
+			}
+		case "ENDTRNS":
+			if balance != 0 {
+				t.Errorf("transaction %d is off by %s", transactions, formatDecimal(balance, 2))
+			}
+			balance = 0
+		}
+	}
+	// one per month, production and currency
+	if transactions != 4 {
+		t.Errorf("got %d transactions, want 4", transactions)
+	}
+	if !strings.Contains(b.String(), "TRNS\t\tGENERAL JOURNAL\t04/30/2024\tStripe CAD\t\t-56.34\t") {
+		t.Errorf("missing the April refund of Apprendre Go on the last day of the month:\n%s", b.String())
+	}
+	// the refund gives back its taxes, only the sale goes to the refunds
+	for _, split := range []string{
+		"SPL\t\tGENERAL JOURNAL\t04/30/2024\tRemboursements\t\t49.00\t",
+		"SPL\t\tGENERAL JOURNAL\t04/30/2024\tTPS à payer\t\t2.45\t",
+		"SPL\t\tGENERAL JOURNAL\t04/30/2024\tTVQ à payer\t\t4.89\t",
+	} {
+		if !strings.Contains(b.String(), split) {
+			t.Errorf("missing %q in:\n%s", split, b.String())
+		}
+	}
+}
+
+func TestRefundedTaxes(t *testing.T) {
+	taxes := Taxes{Country: "CA", Province: "QC", GST: 245, QST: 489}
+	if got := refundedTaxes(taxes, 5634, 5634); got != taxes {
+		t.Errorf("refundedTaxes(full refund) = %+v, want %+v", got, taxes)
+	}
+	want := Taxes{Country: "CA", Province: "QC", GST: 122, QST: 244}
+	if got := refundedTaxes(taxes, 5634, 2817); got != want {
+		t.Errorf("refundedTaxes(half refund) = %+v, want %+v", got, want)
+	}
+	if got := refundedTaxes(taxes, 0, 100); got.Total() != 0 {
+		t.Errorf("refundedTaxes(free purchase) = %+v, want none", got)
+	}
+}
+
+func TestOrderAllocateFee(t *testing.T) {
+	o := &Order{Lines: []*OrderLine{
+		{Purchase: &Purchase{Amount: cad(5634)}},
+		{Purchase: &Purchase{Amount: cad(3248)}},
+		{Purchase: &Purchase{Amount: cad(0)}},
+	}}
+	o.allocateFee(cad(287))
+
+	total := 0
+	for _, l := range o.Lines {
+		total += l.Purchase.Fees.Amount
+	}
+	if total != 287 {
+		t.Errorf("allocated %d, want the whole fee 287", total)
+	}
+	if o.Lines[0].Purchase.Fees.Amount != 182 || o.Lines[1].Purchase.Fees.Amount != 104 {
+		t.Errorf("fees = %v, %v, want 182 and 104", *o.Lines[0].Purchase.Fees, *o.Lines[1].Purchase.Fees)
+	}
+}
+
+func TestPurchaseFees(t *testing.T) {
+	t.Setenv("PAYMENT_FEE_PERCENT", "")
+	t.Setenv("PAYMENT_FEE_FIXED", "")
+
+	p := &Purchase{Amount: cad(10000)}
+	if got := purchaseFees(p, cad(10000)); got != cad(320) {
+		t.Errorf("purchaseFees() without recorded fees = %v, want the estimate", got)
+	}
+
+	p.Fees = &Money{Amount: 317, Currency: defaultCurrency}
+	if got := purchaseFees(p, cad(10000)); got != cad(317) {
+		t.Errorf("purchaseFees() = %v, want the recorded fees", got)
+	}
+
+	p.Fees = &Money{Amount: 317, Currency: "USD"}
+	if got := purchaseFees(p, cad(10000)); got != cad(320) {
+		t.Errorf("purchaseFees() in another currency = %v, want the estimate", got)
+	}
+}
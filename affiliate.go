@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const refCookie = "ref"
+
+// affiliateWindow returns how long a referral is attributed to its affiliate,
+// AFFILIATE_WINDOW_DAYS or 30 days by default
+func affiliateWindow() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("AFFILIATE_WINDOW_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// referral remembers the affiliate of a visitor arriving with ?ref=code, the
+// last affiliate visited wins
+func referral(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := strings.TrimSpace(r.URL.Query().Get("ref")); len(code) > 0 {
+			if a, err := GetAffiliate(-1, code); err == nil && a.IsActive {
+				http.SetCookie(w, &http.Cookie{
+					Name:     refCookie,
+					Value:    a.Code,
+					Path:     "/",
+					Expires:  time.Now().Add(affiliateWindow()),
+					HttpOnly: true,
+				})
+			} else {
+				log.Println("unknown referral code: " + code)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// visitorAffiliate returns the affiliate the visitor was referred by, if any,
+// affiliates do not earn a commission on their own purchases
+func visitorAffiliate(r *http.Request, buyerEmail string) *Affiliate {
+	c, err := r.Cookie(refCookie)
+	if err != nil || len(c.Value) == 0 {
+		return nil
+	}
+
+	a, err := GetAffiliate(-1, c.Value)
+	if err != nil || !a.IsActive || strings.EqualFold(a.Email, buyerEmail) {
+		return nil
+	}
+	return a
+}
+
+// commission returns the affiliate's share of an amount before taxes
+func (a *Affiliate) commission(subtotal Money) Money {
+	return Money{Amount: subtotal.Amount * a.CommissionPercent / 100, Currency: subtotal.Currency}
+}
+
+// commissionEntry is a purchase made through an affiliate, or a refund of
+// one when Refunded is set. Amounts are in cents of its currency
+type commissionEntry struct {
+	Code       string
+	Name       string
+	Email      string
+	Currency   string
+	Subtotal   int
+	Commission int
+	Amount     int
+	Refunded   int
+}
+
+// refundShare returns the part of a purchase value taken back by the refund,
+// in proportion of the amount refunded taxes included
+func (e *commissionEntry) refundShare(v int) int {
+	if e.Amount == 0 {
+		return 0
+	}
+	return v * e.Refunded / e.Amount
+}
+
+// commissionLine is the sales made by an affiliate in a currency over a
+// period, or the refunds of their sales with negative amounts
+type commissionLine struct {
+	Code       string
+	Name       string
+	Email      string
+	Refund     bool
+	Count      int
+	Sales      Money
+	Commission Money
+}
+
+// commissionLines sums the entries per affiliate and currency, the refunds
+// follow the sales on their own line
+func commissionLines(entries []*commissionEntry) []*commissionLine {
+	byKey := make(map[string]*commissionLine)
+	var lines []*commissionLine
+	for _, e := range entries {
+		refund := e.Refunded > 0
+		k := fmt.Sprintf("%s|%s|%t", e.Code, e.Currency, refund)
+		l, ok := byKey[k]
+		if !ok {
+			l = &commissionLine{Code: e.Code, Name: e.Name, Email: e.Email, Refund: refund}
+			l.Sales.Currency = e.Currency
+			l.Commission.Currency = e.Currency
+			byKey[k] = l
+			lines = append(lines, l)
+		}
+
+		l.Count++
+		if refund {
+			l.Sales.Amount -= e.refundShare(e.Subtotal)
+			l.Commission.Amount -= e.refundShare(e.Commission)
+		} else {
+			l.Sales.Amount += e.Subtotal
+			l.Commission.Amount += e.Commission
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].Code != lines[j].Code {
+			return lines[i].Code < lines[j].Code
+		}
+		if lines[i].Sales.Currency != lines[j].Sales.Currency {
+			return lines[i].Sales.Currency < lines[j].Sales.Currency
+		}
+		return !lines[i].Refund && lines[j].Refund
+	})
+	return lines
+}
+
+// writeCommissionsCSV writes the commission report of a month as CSV
+func writeCommissionsCSV(w io.Writer, month time.Time, lines []*commissionLine) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Mois", "Code", "Nom", "Courriel", "Devise", "Type", "Nombre", "Montant des ventes", "Commission"})
+	for _, l := range lines {
+		kind := "Ventes"
+		if l.Refund {
+			kind = "Remboursements"
+		}
+		cw.Write([]string{
+			month.Format("2006-01"),
+			l.Code,
+			l.Name,
+			l.Email,
+			l.Sales.Currency,
+			kind,
+			strconv.Itoa(l.Count),
+			formatDecimal(l.Sales.Amount, 2),
+			formatDecimal(l.Commission.Amount, 2),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommissionLinesWithRefund(t *testing.T) {
+	// two sales of 49 $ plus Quebec taxes with a 20 % commission, half of a
+	// third one refunded and a USD sale refunded in full
+	entries := []*commissionEntry{
+		{Code: "marie", Name: "Marie", Email: "marie@example.com", Currency: "CAD", Subtotal: 4900, Commission: 980, Amount: 5634},
+		{Code: "marie", Name: "Marie", Email: "marie@example.com", Currency: "CAD", Subtotal: 4900, Commission: 980, Amount: 5634},
+		{Code: "marie", Name: "Marie", Email: "marie@example.com", Currency: "CAD", Subtotal: 4900, Commission: 980, Amount: 5634, Refunded: 2817},
+		{Code: "jean", Name: "Jean", Email: "jean@example.com", Currency: "USD", Subtotal: 3900, Commission: 390, Amount: 3900, Refunded: 3900},
+		{Code: "jean", Name: "Jean", Email: "jean@example.com", Currency: "USD", Subtotal: 3900, Commission: 390, Amount: 3900},
+	}
+
+	lines := commissionLines(entries)
+	want := []commissionLine{
+		{Code: "jean", Count: 1, Sales: Money{3900, "USD"}, Commission: Money{390, "USD"}},
+		{Code: "jean", Refund: true, Count: 1, Sales: Money{-3900, "USD"}, Commission: Money{-390, "USD"}},
+		{Code: "marie", Count: 2, Sales: cad(9800), Commission: cad(1960)},
+		{Code: "marie", Refund: true, Count: 1, Sales: cad(-2450), Commission: cad(-490)},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, w := range want {
+		l := lines[i]
+		if l.Code != w.Code || l.Refund != w.Refund || l.Count != w.Count || l.Sales != w.Sales || l.Commission != w.Commission {
+			t.Errorf("line %d = %+v, want %+v", i, *l, w)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := writeCommissionsCSV(&b, time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local), lines); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "marie,Marie,marie@example.com,CAD,Remboursements,1,-24.50,-4.90\n") {
+		t.Errorf("CSV has no refund line for marie:\n%s", b.String())
+	}
+}
+
+func TestCommissionEntryRefundShare(t *testing.T) {
+	e := &commissionEntry{Subtotal: 1000, Commission: 333, Amount: 1150, Refunded: 575}
+	if got := e.refundShare(e.Commission); got != 166 {
+		t.Errorf("refundShare(333) = %d, want 166", got)
+	}
+	if got := (&commissionEntry{Refunded: 100}).refundShare(100); got != 0 {
+		t.Errorf("refundShare of a free purchase = %d, want 0", got)
+	}
+}
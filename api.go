@@ -2,20 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func auth(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := r.Header.Get("X-Api-Key")
 		log.Printf("Inside middleware with key %s", key)
-		if len(key) == 0 || key != "1234" {
-			respond(w, r, http.StatusUnauthorized, nil)
-		} else {
+		if len(key) > 0 && key == "1234" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// a signed in admin also sends the CSRF token of their session to
+		// change anything
+		s := currentSession(r)
+		if s != nil && s.IsAdmin() && (r.Method == "GET" || validCSRF(r)) {
 			h.ServeHTTP(w, r)
+		} else {
+			respond(w, r, http.StatusUnauthorized, nil)
 		}
 	})
 }
@@ -63,9 +74,32 @@ func episodesHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		log.Printf("%v\n", data)
-		// selon the Method, faire un insert ou un update
-		respond(w, r, http.StatusOK, data)
+		if data.ID > 0 {
+			if err := updateEpisode(data); err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+			return
+		}
+
+		p, err := GetProduction(data.ProductionID, "")
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("production not found: %d", data.ProductionID))
+			return
+		}
+
+		id, err := insertEpisode(data)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// ?notify=false adds the episode without emailing the owners
+		if r.URL.Query().Get("notify") != "false" {
+			go notifyNewEpisode(p, data)
+		}
+		respond(w, r, http.StatusCreated, id)
 	} else if r.Method == "DELETE" {
 		log.Println("delete")
 	}
@@ -98,8 +132,23 @@ func productionsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !validPricingMode(data.PricingMode) {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("unknown pricingMode: %s", data.PricingMode))
+			return
+		} else if data.PricingMode == pricingFreeWithEmail && !data.Price.IsZero() {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("free with email productions must have a zero price"))
+			return
+		}
+		if !data.Price.accepts(defaultCurrency) || !data.SalesPrice.accepts(defaultCurrency) || !data.PreorderPrice.accepts(defaultCurrency) {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("price, salesPrice and preorderPrice must be in %s, use prices for other currencies", defaultCurrency))
+			return
+		}
+
 		if data.ID > 0 {
 			err = updateProduction(data)
+			if err == nil {
+				err = saveProductionPrices(data.ID, data.Prices)
+			}
 			if err != nil {
 				respond(w, r, http.StatusInternalServerError, err)
 			} else {
@@ -107,6 +156,9 @@ func productionsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			id, err := insertProduction(data)
+			if err == nil {
+				err = saveProductionPrices(int(id), data.Prices)
+			}
 			if err != nil {
 				respond(w, r, http.StatusInternalServerError, err)
 			} else {
@@ -117,3 +169,589 @@ func productionsHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println("todo delete production")
 	}
 }
+
+func bundlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		id := getID(r.URL.Path, "/api/bundles/")
+		if len(id) > 0 {
+			bundleID, err := strconv.Atoi(id)
+			if err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			b, err := GetBundle(bundleID, "")
+			if err != nil {
+				respond(w, r, http.StatusNotFound, err)
+			} else {
+				respond(w, r, http.StatusOK, b)
+			}
+		} else {
+			bundles, err := GetBundles()
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, bundles)
+			}
+		}
+	} else if r.Method == "POST" || r.Method == "PUT" {
+		var data *Bundle
+		err := parseBody(r.Body, &data)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		if !data.Price.accepts(defaultCurrency) {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("bundle price must be in %s", defaultCurrency))
+			return
+		}
+
+		if data.ID > 0 {
+			err = updateBundle(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+		} else {
+			id, err := insertBundle(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusCreated, id)
+			}
+		}
+	} else if r.Method == "DELETE" {
+		bundleID, err := strconv.Atoi(getID(r.URL.Path, "/api/bundles/"))
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := deleteBundle(bundleID); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			respond(w, r, http.StatusOK, true)
+		}
+	}
+}
+
+func dripsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		id := getID(r.URL.Path, "/api/drips/")
+		if len(id) > 0 {
+			courseID, err := strconv.Atoi(id)
+			if err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			c, err := GetDripCourse(courseID, "")
+			if err != nil {
+				respond(w, r, http.StatusNotFound, err)
+			} else {
+				respond(w, r, http.StatusOK, c)
+			}
+		} else {
+			courses, err := GetDripCourses()
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, courses)
+			}
+		}
+	} else if r.Method == "POST" || r.Method == "PUT" {
+		var data *DripCourse
+		err := parseBody(r.Body, &data)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		for _, s := range data.Steps {
+			if s.DelayDays < 0 {
+				respond(w, r, http.StatusBadRequest, fmt.Errorf("step %s has a negative delay", s.Template))
+				return
+			}
+			if _, err := loadDripStep(s.Template); err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if data.ID > 0 {
+			err = updateDripCourse(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+		} else {
+			id, err := insertDripCourse(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusCreated, id)
+			}
+		}
+	} else if r.Method == "DELETE" {
+		courseID, err := strconv.Atoi(getID(r.URL.Path, "/api/drips/"))
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := deleteDripCourse(courseID); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			respond(w, r, http.StatusOK, true)
+		}
+	}
+}
+
+func salesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		id := getID(r.URL.Path, "/api/sales/")
+		if len(id) > 0 {
+			saleID, err := strconv.Atoi(id)
+			if err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			s, err := GetSale(saleID)
+			if err != nil {
+				respond(w, r, http.StatusNotFound, err)
+			} else {
+				respond(w, r, http.StatusOK, s)
+			}
+		} else {
+			sales, err := GetSales()
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, sales)
+			}
+		}
+	} else if r.Method == "POST" || r.Method == "PUT" {
+		var data *Sale
+		err := parseBody(r.Body, &data)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		if err := data.validate(); err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		data.Price.Currency = defaultCurrency
+
+		if data.ID > 0 {
+			err = updateSale(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+		} else {
+			id, err := insertSale(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusCreated, id)
+			}
+		}
+	} else if r.Method == "DELETE" {
+		saleID, err := strconv.Atoi(getID(r.URL.Path, "/api/sales/"))
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := deleteSale(saleID); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			respond(w, r, http.StatusOK, true)
+		}
+	}
+}
+
+func affiliatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		id := getID(r.URL.Path, "/api/affiliates/")
+		if len(id) > 0 {
+			affiliateID, err := strconv.Atoi(id)
+			if err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			a, err := GetAffiliate(affiliateID, "")
+			if err != nil {
+				respond(w, r, http.StatusNotFound, err)
+			} else {
+				respond(w, r, http.StatusOK, a)
+			}
+		} else {
+			affiliates, err := GetAffiliates()
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, affiliates)
+			}
+		}
+	} else if r.Method == "POST" || r.Method == "PUT" {
+		var data *Affiliate
+		err := parseBody(r.Body, &data)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		if len(data.Code) == 0 || data.CommissionPercent < 0 || data.CommissionPercent > 100 {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("a code and a commission percent between 0 and 100 are required"))
+			return
+		}
+
+		if data.ID > 0 {
+			err = updateAffiliate(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+		} else {
+			id, err := insertAffiliate(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusCreated, id)
+			}
+		}
+	}
+}
+
+// commissionsHandler exports the affiliate commissions of a month as CSV,
+// ?month=2006-01 defaults to the previous month
+func commissionsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local)
+	if m := r.URL.Query().Get("month"); len(m) > 0 {
+		t, err := time.ParseInLocation("2006-01", m, time.Local)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		month = t
+	}
+
+	entries, err := getCommissionEntries(month, month.AddDate(0, 1, 0))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	lines := commissionLines(entries)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=commissions-"+month.Format("2006-01")+".csv")
+	if err := writeCommissionsCSV(w, month, lines); err != nil {
+		log.Printf("error on commissionsHandler: %s", err)
+	}
+}
+
+func accountingHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := parseAccountingPeriod(q.Get("from"), q.Get("to"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := getSalesEntries(from, to)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	filename := "ventes-" + from.Format("2006-01-02") + "-" + to.AddDate(0, 0, -1).Format("2006-01-02")
+	if q.Get("format") == "iif" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".iif")
+		err = writeSalesIIF(w, entries)
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".csv")
+		err = writeSalesCSV(w, entries)
+	}
+	if err != nil {
+		log.Printf("error on accountingHandler: %s", err)
+	}
+}
+
+func instructorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		id := getID(r.URL.Path, "/api/instructors/")
+		if len(id) > 0 {
+			instructorID, err := strconv.Atoi(id)
+			if err != nil {
+				respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			i, err := GetInstructor(instructorID)
+			if err != nil {
+				respond(w, r, http.StatusNotFound, err)
+			} else {
+				respond(w, r, http.StatusOK, i)
+			}
+		} else {
+			instructors, err := GetInstructors()
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, instructors)
+			}
+		}
+	} else if r.Method == "POST" || r.Method == "PUT" {
+		var data *Instructor
+		err := parseBody(r.Body, &data)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		if len(data.Name) == 0 || data.SharePercent < 0 || data.SharePercent > 100 {
+			respond(w, r, http.StatusBadRequest, fmt.Errorf("a name and a share percent between 0 and 100 are required"))
+			return
+		}
+
+		if data.ID > 0 {
+			err = updateInstructor(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusOK, true)
+			}
+		} else {
+			id, err := insertInstructor(data)
+			if err != nil {
+				respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				respond(w, r, http.StatusCreated, id)
+			}
+		}
+	}
+}
+
+// royaltiesHandler returns the monthly royalty statement of an instructor as
+// CSV, or as PDF with format=pdf. ?month=2006-01 defaults to the previous month.
+func royaltiesHandler(w http.ResponseWriter, r *http.Request) {
+	instructorID, err := strconv.Atoi(r.URL.Query().Get("instructor"))
+	if err != nil {
+		respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	i, err := GetInstructor(instructorID)
+	if err != nil {
+		respond(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.Local)
+	if m := r.URL.Query().Get("month"); len(m) > 0 {
+		t, err := time.ParseInLocation("2006-01", m, time.Local)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		month = t
+	}
+
+	lines, err := getRoyaltyStatement(i.ID, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	filename := fmt.Sprintf("redevances-%d-%s", i.ID, month.Format("2006-01"))
+	if r.URL.Query().Get("format") == "pdf" {
+		data, err := generateRoyaltyPDF(i, month, lines)
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".pdf")
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename+".csv")
+	if err := writeRoyaltyCSV(w, i, month, lines); err != nil {
+		log.Printf("error on royaltiesHandler: %s", err)
+	}
+}
+
+// emailsHandler lists the outbox emails with a status, dead by default, and
+// queues an unsent email again on POST /api/emails/{id}/retry
+func emailsHandler(w http.ResponseWriter, r *http.Request) {
+	id := getID(r.URL.Path, "/api/emails/")
+	if r.Method == "POST" && strings.HasSuffix(id, "/retry") {
+		emailID, err := strconv.Atoi(strings.TrimSuffix(id, "/retry"))
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := retryOutboxEmail(emailID); err != nil {
+			respond(w, r, http.StatusNotFound, err)
+			return
+		}
+		wakeOutbox()
+		respond(w, r, http.StatusOK, nil)
+		return
+	} else if r.Method != "GET" {
+		respond(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	if len(id) > 0 {
+		emailID, err := strconv.Atoi(id)
+		if err != nil {
+			respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		e, err := GetOutboxEmail(emailID)
+		if err != nil {
+			respond(w, r, http.StatusNotFound, err)
+		} else {
+			respond(w, r, http.StatusOK, e)
+		}
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = outboxDead
+	}
+
+	emails, err := GetOutboxEmails(status)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		respond(w, r, http.StatusOK, emails)
+	}
+}
+
+// messagesHandler lists the latest messages of the contact form
+func messagesHandler(w http.ResponseWriter, r *http.Request) {
+	messages, err := GetContactMessages()
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+	} else {
+		respond(w, r, http.StatusOK, messages)
+	}
+}
+
+// suppressionsHandler lists the addresses we no longer email, a DELETE of
+// /api/suppressions/{email} emails it again once support fixed the problem
+// and clears the undelivered flag of its purchases
+func suppressionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		suppressions, err := GetSuppressions()
+		if err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			respond(w, r, http.StatusOK, suppressions)
+		}
+	} else if r.Method == "DELETE" {
+		email := getID(r.URL.Path, "/api/suppressions/")
+		if len(email) == 0 {
+			respond(w, r, http.StatusBadRequest, nil)
+			return
+		}
+
+		if err := deleteSuppression(email); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else if err := clearUndeliveredPurchases(email); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			respond(w, r, http.StatusOK, true)
+		}
+	}
+}
+
+// purchasesHandler returns what a customer paid for each purchase and why,
+// purchases made before the price history have no price record
+func purchasesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respond(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if len(email) == 0 {
+		respond(w, r, http.StatusBadRequest, fmt.Errorf("email is required"))
+		return
+	}
+
+	purchases, err := GetPurchases(email)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	type purchaseHistory struct {
+		*Purchase
+		Price *PriceRecord `json:"price"`
+	}
+
+	var history []purchaseHistory
+	for _, p := range purchases {
+		pr, _ := GetPriceRecord(p.ID)
+		history = append(history, purchaseHistory{Purchase: p, Price: pr})
+	}
+	respond(w, r, http.StatusOK, history)
+}
+
+// paymentWebhookHandler receives the subscription renewals and cancellations
+// and the refunds notified by the payment provider
+func paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	e, err := payments.ParseEvent(r)
+	if err != nil {
+		log.Printf("error on paymentWebhookHandler: %s", err)
+		respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if e != nil && e.Type == chargeRefunded {
+		log.Printf("charge %s refunded %s", e.ChargeID, e.Refunded)
+		if err := recordRefund(e.ChargeID, e.Refunded.Amount, e.RefundedOn); err != nil {
+			// subscription invoices are refunded too and have no purchase
+			log.Printf("error on paymentWebhookHandler: %s", err)
+		}
+	} else if e != nil {
+		log.Printf("subscription %s %s until %s", e.Subscription.SubscriptionID, e.Type, e.Subscription.PeriodEnd)
+		if e.Type == subscriptionCanceled {
+			e.Subscription.Status = "canceled"
+		}
+
+		if err := updateSubscription(e.Subscription); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	respond(w, r, http.StatusOK, true)
+}
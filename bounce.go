@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// reasons an address is suppressed
+const (
+	suppressionBounce    = "bounce"
+	suppressionComplaint = "complaint"
+)
+
+// deliveryEvent is a permanent bounce or a complaint reported for an address,
+// MessageID is the email it is about when the provider reports it
+type deliveryEvent struct {
+	Email     string
+	Reason    string
+	Detail    string
+	MessageID string
+}
+
+// suppress adds the address of a delivery event to the suppression list, the
+// purchases are flagged for support when their confirmation bounced
+func suppress(e *deliveryEvent) error {
+	log.Printf("suppressing %s after a %s: %s", e.Email, e.Reason, e.Detail)
+	err := insertSuppression(&Suppression{Email: e.Email, Reason: e.Reason, Detail: e.Detail, SuppressedOn: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	if e.Reason == suppressionBounce && len(e.MessageID) > 0 {
+		return flagUndeliveredPurchases(e.MessageID)
+	}
+	return nil
+}
+
+// trimMessageID removes the angle brackets some events keep around a Message-ID
+func trimMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// mailgunWebhookKey returns the key signing the Mailgun webhooks,
+// MG_WEBHOOK_KEY or the API key
+func mailgunWebhookKey() string {
+	if key := os.Getenv("MG_WEBHOOK_KEY"); len(key) > 0 {
+		return key
+	}
+	return os.Getenv("MG_KEY")
+}
+
+// verifyMailgunSignature checks the HMAC of the timestamp and token of a
+// Mailgun webhook
+func verifyMailgunSignature(timestamp, token, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(mailgunWebhookKey()))
+	mac.Write([]byte(timestamp + token))
+	sig, err := hex.DecodeString(signature)
+	return err == nil && len(mailgunWebhookKey()) > 0 && hmac.Equal(sig, mac.Sum(nil))
+}
+
+// mailgunEvent is the JSON body of the Mailgun webhooks
+type mailgunEvent struct {
+	Signature struct {
+		Timestamp string `json:"timestamp"`
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	} `json:"signature"`
+	EventData struct {
+		Event     string `json:"event"`
+		Severity  string `json:"severity"`
+		Recipient string `json:"recipient"`
+		Reason    string `json:"reason"`
+		Message   struct {
+			Headers struct {
+				MessageID string `json:"message-id"`
+			} `json:"headers"`
+		} `json:"message"`
+		DeliveryStatus struct {
+			Message     string `json:"message"`
+			Description string `json:"description"`
+		} `json:"delivery-status"`
+	} `json:"event-data"`
+}
+
+// parseMailgunEvent reads a JSON webhook or a legacy form encoded one, it
+// returns nil for the events we ignore like temporary failures
+func parseMailgunEvent(r *http.Request) (*deliveryEvent, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var m mailgunEvent
+		if err := parseBody(r.Body, &m); err != nil {
+			return nil, err
+		}
+		if !verifyMailgunSignature(m.Signature.Timestamp, m.Signature.Token, m.Signature.Signature) {
+			return nil, errors.New("invalid signature")
+		}
+
+		d := m.EventData
+		detail := strings.TrimSpace(d.DeliveryStatus.Message + " " + d.DeliveryStatus.Description)
+		switch {
+		case d.Event == "failed" && d.Severity == "permanent":
+			return &deliveryEvent{Email: d.Recipient, Reason: suppressionBounce, Detail: detail, MessageID: trimMessageID(d.Message.Headers.MessageID)}, nil
+		case d.Event == "complained":
+			return &deliveryEvent{Email: d.Recipient, Reason: suppressionComplaint}, nil
+		}
+		return nil, nil
+	}
+
+	if !verifyMailgunSignature(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")) {
+		return nil, errors.New("invalid signature")
+	}
+
+	detail := strings.TrimSpace(r.FormValue("error") + " " + r.FormValue("description"))
+	switch r.FormValue("event") {
+	case "bounced", "dropped":
+		return &deliveryEvent{Email: r.FormValue("recipient"), Reason: suppressionBounce, Detail: detail, MessageID: trimMessageID(r.FormValue("Message-Id"))}, nil
+	case "complained":
+		return &deliveryEvent{Email: r.FormValue("recipient"), Reason: suppressionComplaint}, nil
+	}
+	return nil, nil
+}
+
+func mailgunWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	e, err := parseMailgunEvent(r)
+	if err != nil {
+		log.Printf("error on mailgunWebhookHandler: %s", err)
+		// Mailgun stops retrying on 406
+		respond(w, r, http.StatusNotAcceptable, err)
+		return
+	}
+
+	if e != nil {
+		if err := suppress(e); err != nil {
+			respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	respond(w, r, http.StatusOK, true)
+}
+
+// emailWebhookHandler receives the events of other providers or scripts as
+// {"type": "bounce|complaint", "email": "...", "reason": "...", "messageId":
+// "..."}, the Message-ID of the bounced email being optional, authenticated
+// with the EMAIL_WEBHOOK_SECRET in the X-Webhook-Secret header
+func emailWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respond(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	secret := os.Getenv("EMAIL_WEBHOOK_SECRET")
+	if len(secret) == 0 || !hmac.Equal([]byte(r.Header.Get("X-Webhook-Secret")), []byte(secret)) {
+		respond(w, r, http.StatusUnauthorized, nil)
+		return
+	}
+
+	var data struct {
+		Type      string `json:"type"`
+		Email     string `json:"email"`
+		Reason    string `json:"reason"`
+		MessageID string `json:"messageId"`
+	}
+	if err := parseBody(r.Body, &data); err != nil {
+		respond(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	email := strings.TrimSpace(data.Email)
+	if !strings.Contains(email, "@") {
+		respond(w, r, http.StatusBadRequest, errors.New("invalid email: "+email))
+		return
+	} else if data.Type != suppressionBounce && data.Type != suppressionComplaint {
+		respond(w, r, http.StatusBadRequest, errors.New("type must be bounce or complaint"))
+		return
+	}
+
+	if err := suppress(&deliveryEvent{Email: email, Reason: data.Type, Detail: data.Reason, MessageID: trimMessageID(data.MessageID)}); err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	respond(w, r, http.StatusOK, true)
+}
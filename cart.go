@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const cartCookie = "cart"
+
+// Cart is a visitor's shopping cart, kept in the database and identified by
+// the cart cookie
+type Cart struct {
+	ID          string
+	Productions []*Production
+}
+
+// Subtotal returns the cart amount before taxes in the productions currency
+func (c *Cart) Subtotal() Money {
+	m := Money{Currency: defaultCurrency}
+	if len(c.Productions) > 0 {
+		m.Currency = c.Productions[0].CurrentPrice.Currency
+	}
+
+	for _, p := range c.Productions {
+		m = m.Add(p.CurrentPrice.Amount)
+	}
+	return m
+}
+
+// Contains returns true if the production is already in the cart
+func (c *Cart) Contains(productionID int) bool {
+	for _, p := range c.Productions {
+		if p.ID == productionID {
+			return true
+		}
+	}
+	return false
+}
+
+// cartID returns the visitor's cart id, a new one is created and set in the
+// cart cookie when create is true and the visitor does not have a cart yet
+func cartID(w http.ResponseWriter, r *http.Request, create bool) string {
+	if c, err := r.Cookie(cartCookie); err == nil && len(c.Value) > 0 {
+		return c.Value
+	}
+
+	if !create {
+		return ""
+	}
+
+	id := randomToken(16)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  time.Now().AddDate(0, 0, 30),
+		HttpOnly: true,
+	})
+	return id
+}
+
+// getCart returns the visitor's cart with its productions priced in the
+// visitor's currency when every production is sold in it
+func getCart(w http.ResponseWriter, r *http.Request) (*Cart, error) {
+	c := &Cart{ID: cartID(w, r, false)}
+	if len(c.ID) == 0 {
+		return c, nil
+	}
+
+	ids, err := getCartItems(c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		p, err := GetProduction(id, "")
+		if err != nil {
+			return nil, err
+		}
+		c.Productions = append(c.Productions, p)
+	}
+
+	orderCurrency(c.Productions, visitorCurrency(r))
+	return c, nil
+}
+
+func getCartItems(cartID string) ([]int, error) {
+	sql, err := db.Prepare("SELECT ProductionID FROM CartItems WHERE CartID = ? ORDER BY AddedOn")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func addCartItem(cartID string, productionID int) error {
+	_, err := db.Exec(`MERGE Carts AS c
+  USING (SELECT ? AS ID) AS src ON c.ID = src.ID
+  WHEN MATCHED THEN UPDATE SET UpdatedOn = ?
+  WHEN NOT MATCHED THEN INSERT (ID, UpdatedOn) VALUES (src.ID, ?);`, cartID, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`IF NOT EXISTS (SELECT 1 FROM CartItems WHERE CartID = ? AND ProductionID = ?)
+  INSERT INTO CartItems (CartID, ProductionID, AddedOn) VALUES (?, ?, ?)`,
+		cartID, productionID, cartID, productionID, time.Now())
+	return err
+}
+
+func removeCartItem(cartID string, productionID int) error {
+	_, err := db.Exec("DELETE FROM CartItems WHERE CartID = ? AND ProductionID = ?", cartID, productionID)
+	return err
+}
+
+func deleteCart(cartID string) error {
+	_, err := db.Exec("DELETE FROM Carts WHERE ID = ?", cartID)
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runCommand runs the command line tool named by args[0] instead of the web
+// server, it returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "export-sales":
+		return exportSalesCommand(args[1:])
+	case "campaign":
+		return campaignCommand(args[1:])
+	}
+
+	fmt.Fprintln(os.Stderr, "unknown command: "+args[0])
+	fmt.Fprintln(os.Stderr, "commands: export-sales, campaign")
+	return 2
+}
+
+// campaignCommand queues a campaign of emails/campaigns/ for the confirmed
+// subscribers, i.e. campaign -name nouvelle-formation -test moi@exemple.com
+func campaignCommand(args []string) int {
+	fs := flag.NewFlagSet("campaign", flag.ContinueOnError)
+	name := fs.String("name", "", "campaign template in emails/campaigns/, without extension")
+	test := fs.String("test", "", "send only to this address")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*name) == 0 {
+		fmt.Fprintln(os.Stderr, "-name is required")
+		return 2
+	}
+
+	sent, err := sendCampaign(*name, *test)
+	fmt.Printf("%d emails queued\n", sent)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// exportSalesCommand writes the accounting export of a date range, i.e.
+// export-sales -from 2024-01-01 -to 2024-12-31 -format iif -o ventes.iif
+func exportSalesCommand(args []string) int {
+	fs := flag.NewFlagSet("export-sales", flag.ContinueOnError)
+	from := fs.String("from", "", "first day of the export, YYYY-MM-DD (default: first day of last month)")
+	to := fs.String("to", "", "last day of the export, YYYY-MM-DD (default: last day of last month)")
+	format := fs.String("format", "csv", "csv or iif")
+	out := fs.String("o", "", "output file (default: standard output)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	start, end, err := parseAccountingPeriod(*from, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	entries, err := getSalesEntries(start, end)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "csv":
+		err = writeSalesCSV(w, entries)
+	case "iif":
+		err = writeSalesIIF(w, entries)
+	default:
+		err = fmt.Errorf("unknown format: %s", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
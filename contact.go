@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// contactLimit is how many messages a visitor can send per contactWindow
+const (
+	contactLimit  = 3
+	contactWindow = time.Hour
+)
+
+// supportEmail returns where the contact form is sent, SUPPORT_EMAIL or
+// support@focuscentric.com by default
+func supportEmail() string {
+	if to := os.Getenv("SUPPORT_EMAIL"); len(to) > 0 {
+		return to
+	}
+	return "support@focuscentric.com"
+}
+
+// validateContact returns the problem with a message to show the visitor, or
+// an empty string when it can be sent
+func validateContact(m *ContactMessage) string {
+	switch {
+	case len(m.Name) == 0 || utf8.RuneCountInString(m.Name) > 100:
+		return "Veuillez indiquer votre nom."
+	case !strings.Contains(m.Email, "@") || len(m.Email) > 250 || strings.ContainsAny(m.Email, " \r\n<>"):
+		return "Cette adresse courriel n'est pas valide."
+	case utf8.RuneCountInString(m.Subject) > 200:
+		return "Le sujet ne peut dépasser 200 caractères."
+	case utf8.RuneCountInString(m.Message) < 10:
+		return "Votre message est trop court."
+	case utf8.RuneCountInString(m.Message) > 5000:
+		return "Votre message ne peut dépasser 5000 caractères."
+	}
+	return ""
+}
+
+// sendContactMessage saves a message and emails it to support, replies go to
+// the visitor
+func sendContactMessage(m *ContactMessage) error {
+	if err := insertContactMessage(m); err != nil {
+		return err
+	}
+
+	subject, html, text, err := renderEmail(contactEmail{Message: m})
+	if err != nil {
+		return err
+	}
+	return sendMail(&email{
+		From:    mailSender(),
+		To:      supportEmail(),
+		ReplyTo: m.Email,
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+	})
+}
+
+func contactHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Nous contacter", LatestEpisodes: latestEpisodes[0:3]}
+	if r.Method != http.MethodPost {
+		if err := render(w, r, "contact.html", d); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	m := &ContactMessage{
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Email:   strings.TrimSpace(r.FormValue("email")),
+		Subject: strings.TrimSpace(r.FormValue("subject")),
+		Message: strings.TrimSpace(r.FormValue("message")),
+		IP:      clientIP(r),
+	}
+	d.Contact = m
+
+	// the website field is hidden to visitors, only bots fill it and they
+	// are thanked without sending anything
+	if len(r.FormValue("website")) > 0 {
+		log.Printf("contact form honeypot filled from %s", m.IP)
+		d.Contact = nil
+		d.Message = "Merci! Nous vous répondrons dans les plus brefs délais."
+	} else if msg := validateContact(m); len(msg) > 0 {
+		d.Message = msg
+	} else if !allowRequest("contact", m.IP, contactLimit, contactWindow) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		d.Message = "Vous avez envoyé plusieurs messages récemment, veuillez réessayer plus tard."
+	} else if err := sendContactMessage(m); err != nil {
+		log.Printf("error on contactHandler: %s", err)
+		d.Message = "Votre message n'a pu être envoyé, veuillez réessayer plus tard ou nous écrire à " + supportEmail() + "."
+	} else {
+		d.Contact = nil
+		d.Message = "Merci! Nous vous répondrons dans les plus brefs délais."
+	}
+
+	if err := render(w, r, "contact.html", d); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateContact(t *testing.T) {
+	valid := func() *ContactMessage {
+		return &ContactMessage{Name: "Marie", Email: "marie@example.com", Subject: "Facture", Message: "Pouvez-vous m'envoyer ma facture?"}
+	}
+
+	if got := validateContact(valid()); got != "" {
+		t.Errorf("validateContact(valid) = %q, want no problem", got)
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *ContactMessage)
+		want   string
+	}{
+		{"no name", func(m *ContactMessage) { m.Name = "" }, "Veuillez indiquer votre nom."},
+		{"long name", func(m *ContactMessage) { m.Name = strings.Repeat("é", 101) }, "Veuillez indiquer votre nom."},
+		{"no @", func(m *ContactMessage) { m.Email = "marie.example.com" }, "Cette adresse courriel n'est pas valide."},
+		{"header injection", func(m *ContactMessage) { m.Email = "marie@example.com\r\nBcc: x@example.com" }, "Cette adresse courriel n'est pas valide."},
+		{"display name", func(m *ContactMessage) { m.Email = "Marie <marie@example.com>" }, "Cette adresse courriel n'est pas valide."},
+		{"long email", func(m *ContactMessage) { m.Email = strings.Repeat("a", 250) + "@example.com" }, "Cette adresse courriel n'est pas valide."},
+		{"long subject", func(m *ContactMessage) { m.Subject = strings.Repeat("é", 201) }, "Le sujet ne peut dépasser 200 caractères."},
+		{"short message", func(m *ContactMessage) { m.Message = "Allô" }, "Votre message est trop court."},
+		{"long message", func(m *ContactMessage) { m.Message = strings.Repeat("a", 5001) }, "Votre message ne peut dépasser 5000 caractères."},
+	}
+	for _, tt := range tests {
+		m := valid()
+		tt.change(m)
+		if got := validateContact(m); got != tt.want {
+			t.Errorf("%s: validateContact = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	m := valid()
+	m.Subject = strings.Repeat("é", 200)
+	m.Message = strings.Repeat("é", 5000)
+	if got := validateContact(m); got != "" {
+		t.Errorf("validateContact at the limits = %q, want no problem", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"10.0.0.1:5000", nil, "10.0.0.1"},
+		{"10.0.0.1:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.1:5000", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.1:5000", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.1:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"10.0.0.1:5000", []string{"1.2.3.4, 203.0.113.7, 192.0.2.1"}, "203.0.113.7"},
+		{"10.0.0.1:5000", []string{"203.0.113.7, pas une ip"}, "10.0.0.1"},
+		{"192.0.2.1:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		// a visitor reaching us directly cannot choose its address
+		{"198.51.100.9:5000", []string{"203.0.113.7"}, "198.51.100.9"},
+		{"[2001:db8::2]:5000", []string{"203.0.113.7"}, "2001:db8::2"},
+		{"pas-une-adresse", []string{"203.0.113.7"}, "pas-une-adresse"},
+		{strings.Repeat("a", 80), nil, strings.Repeat("a", 50)},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/contact", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%q, %q) = %q, want %q", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPWithoutTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	r := httptest.NewRequest("POST", "/contact", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Errorf("clientIP() = %q, want the connection address", got)
+	}
+}
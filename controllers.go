@@ -2,19 +2,15 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/base64"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/stripe/stripe-go"
-	"github.com/stripe/stripe-go/charge"
 )
 
 type pageData struct {
@@ -27,17 +23,31 @@ type pageData struct {
 	Posts             []*Post
 	Entry             *Post
 	Tags              map[string]string
+	Currency          string
+	Currencies        []Currency
+	Cart              *Cart
+	Bundle            *Bundle
+	DripCourse        *DripCourse
+	Plans             []*subscriptionPlan
+	Subscription      *Subscription
+	Library           []*libraryItem
+	HasAccess         bool
+	License           *teamLicense
+	Seats             int
+	MaxSeats          int
+	Total             Money
+	Message           string
+	Contact           *ContactMessage
+	Customer          string
+	Purchases         []*libraryPurchase
+	Token             string
+	CSRF              string
 }
 
-var purchaseTmpl *template.Template
-
-func init() {
-	t, err := template.ParseFiles("emails/purchase.html")
-	if err != nil {
-		log.Println(err.Error())
-	} else {
-		purchaseTmpl = t
-	}
+// libraryItem is a production the visitor can download
+type libraryItem struct {
+	Production *Production
+	Token      string
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,8 +57,16 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
 		return
 	}
-	d := &pageData{Title: "Focus Centric - Formations video techniques", CurrentProduction: prod, LatestEpisodes: latestEpisodes[0:3]}
-	if err := render(w, "index.html", d); err != nil {
+	currency := visitorCurrency(r)
+	prod.setCurrency(currency)
+	d := &pageData{
+		Title:             "Focus Centric - Formations video techniques",
+		CurrentProduction: prod,
+		LatestEpisodes:    latestEpisodes[0:3],
+		Currency:          currency,
+		Currencies:        currencies,
+	}
+	if err := render(w, r, "index.html", d); err != nil {
 		log.Println(err)
 	}
 }
@@ -61,8 +79,19 @@ func collectionsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
 		return
 	}
-	d := &pageData{Title: "Formations: " + id, SubTitle: id, Productions: productions, LatestEpisodes: latestEpisodes[0:3]}
-	if err := render(w, "collections.html", d); err != nil {
+	currency := visitorCurrency(r)
+	for _, p := range productions {
+		p.setCurrency(currency)
+	}
+	d := &pageData{
+		Title:          "Formations: " + id,
+		SubTitle:       id,
+		Productions:    productions,
+		LatestEpisodes: latestEpisodes[0:3],
+		Currency:       currency,
+		Currencies:     currencies,
+	}
+	if err := render(w, r, "collections.html", d); err != nil {
 		log.Println(err)
 	}
 }
@@ -75,13 +104,36 @@ func productionHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
 		return
 	}
+	currency := visitorCurrency(r)
+	production.setCurrency(currency)
 	d := &pageData{
 		Title:             production.Title,
 		SubTitle:          categoryToSlug(production.Category),
 		CurrentProduction: production,
 		LatestEpisodes:    latestEpisodes[0:3],
+		Currency:          currency,
+		Currencies:        currencies,
+		MaxSeats:          maxSeats,
 	}
-	if err := render(w, "production.html", d); err != nil {
+	if err := render(w, r, "production.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func bundleHandler(w http.ResponseWriter, r *http.Request) {
+	slug := getID(r.URL.Path, "/bundle/")
+	bundle, err := GetBundle(-1, slug)
+	if err != nil || !bundle.IsActive {
+		log.Printf("error on bundleHandler: %v", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+	d := &pageData{
+		Title:          bundle.Title,
+		Bundle:         bundle,
+		LatestEpisodes: latestEpisodes[0:3],
+	}
+	if err := render(w, r, "bundle.html", d); err != nil {
 		log.Println(err)
 	}
 }
@@ -112,20 +164,25 @@ func episodeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
+	currency := visitorCurrency(r)
+	production.setCurrency(currency)
 	d := &pageData{
 		Title:             current.Title,
 		CurrentEpisode:    current,
 		CurrentProduction: production,
 		LatestEpisodes:    latestEpisodes[0:3],
+		Currency:          currency,
+		Currencies:        currencies,
+		HasAccess:         production.CurrentPrice.IsZero() || hasAccess(visitorEmail(r), production.ID),
 	}
-	if err := render(w, "episode.html", d); err != nil {
+	if err := render(w, r, "episode.html", d); err != nil {
 		log.Println(err)
 	}
 }
 
 func recentHandler(w http.ResponseWriter, r *http.Request) {
 	d := &pageData{Title: "Récemment publiés", LatestEpisodes: latestEpisodes}
-	if err := render(w, "recent.html", d); err != nil {
+	if err := render(w, r, "recent.html", d); err != nil {
 		log.Println(err)
 	}
 }
@@ -150,7 +207,7 @@ func blogHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	d := &pageData{Title: title, LatestEpisodes: latestEpisodes[0:6], Posts: posts, Tags: tags}
-	if err := render(w, "blog.html", d); err != nil {
+	if err := render(w, r, "blog.html", d); err != nil {
 		log.Println(err)
 	}
 }
@@ -176,115 +233,560 @@ func blogEntryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	d := &pageData{Title: entry.Title, LatestEpisodes: latestEpisodes, Entry: entry, Tags: tags}
-	if err := render(w, "post.html", d); err != nil {
+	if err := render(w, r, "post.html", d); err != nil {
 		log.Println(err)
 	}
 }
 
-func contactHandler(w http.ResponseWriter, r *http.Request) {
-	d := &pageData{Title: "Récemment publiés", LatestEpisodes: latestEpisodes[0:3]}
-	if err := render(w, "contact.html", d); err != nil {
-		log.Println(err)
+func buyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
-}
 
-func buyHandler(w http.ResponseWriter, r *http.Request) {
 	handleError := func(w http.ResponseWriter, r *http.Request, msg string) {
 		log.Println(msg)
 		http.Redirect(w, r, "/error", http.StatusBadRequest)
 	}
 
-	token := r.FormValue("stripeToken")
-	email := r.FormValue("stripeEmail")
-	productionID, err := strconv.ParseInt(r.FormValue("id"), 10, 32)
+	var items []*orderItem
+	if len(r.FormValue("bundle")) > 0 {
+		bundleID, err := strconv.Atoi(r.FormValue("bundle"))
+		if err != nil {
+			handleError(w, r, "Invalid bundle id: "+r.FormValue("bundle"))
+			return
+		}
+
+		b, err := GetBundle(bundleID, "")
+		if err != nil || !b.IsActive {
+			handleError(w, r, "Bundle not found")
+			return
+		}
+
+		items, err = bundleItems(b)
+		if err != nil {
+			handleError(w, r, err.Error())
+			return
+		}
+	} else {
+		productionID, err := strconv.ParseInt(r.FormValue("id"), 10, 32)
+		if err != nil {
+			handleError(w, r, "Invalid production id: "+r.FormValue("id"))
+			return
+		}
+
+		p, err := GetProduction(int(productionID), "")
+		if err != nil {
+			handleError(w, r, "Production not found")
+			return
+		}
+
+		currency := strings.ToUpper(r.FormValue("currency"))
+		if len(currency) == 0 {
+			currency = defaultCurrency
+		}
+
+		if len(r.FormValue("recipient")) > 0 {
+			g, err := parseGift(r)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+
+			items, err = productionItems([]*Production{p}, currency)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			items[0].Title = p.Title + " (cadeau)"
+			items[0].Gift = g
+		} else if len(r.FormValue("amount")) > 0 {
+			amount, err := strconv.Atoi(r.FormValue("amount"))
+			if err != nil {
+				handleError(w, r, "Invalid amount: "+r.FormValue("amount"))
+				return
+			}
+
+			item, err := payWhatYouWantItem(p, currency, amount)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			items = []*orderItem{item}
+		} else if len(r.FormValue("seats")) > 0 {
+			seats, err := strconv.Atoi(r.FormValue("seats"))
+			if err != nil {
+				handleError(w, r, "Invalid number of seats: "+r.FormValue("seats"))
+				return
+			}
+
+			item, err := teamLicenseItem(p, currency, seats)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+			items = []*orderItem{item}
+		} else {
+			items, err = productionItems([]*Production{p}, currency)
+			if err != nil {
+				handleError(w, r, err.Error())
+				return
+			}
+		}
+	}
+
+	if _, err := checkout(r, items); err != nil {
+		handleError(w, r, err.Error())
+		return
+	}
+
+	d := &pageData{Title: "Confirmation d'achat", LatestEpisodes: latestEpisodes[0:3]}
+	if err := render(w, r, "confirm.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func payHandler(w http.ResponseWriter, r *http.Request) {
+	productionID, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
-		handleError(w, r, "Invalid production id: "+r.FormValue("id"))
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
 		return
 	}
 
-	p, err := GetProduction(int(productionID), "")
+	production, err := GetProduction(productionID, "")
 	if err != nil {
-		handleError(w, r, "Production not found")
+		log.Printf("error on payHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	stripe.Key = os.Getenv("STRIPE")
-	params := &stripe.ChargeParams{}
-	params.Amount = uint64(p.CurrentPrice)
-	params.Currency = "cad"
-	params.Desc = "Achat de " + p.Title
-	params.SetSource(token)
+	amount, err := parseAmount(r.FormValue("amount"))
+	if err != nil {
+		log.Printf("error on payHandler: %s", err)
+		http.Redirect(w, r, "/production/"+production.Slug, http.StatusSeeOther)
+		return
+	}
 
-	ch, err := charge.New(params)
+	item, err := payWhatYouWantItem(production, visitorCurrency(r), amount)
 	if err != nil {
-		handleError(w, r, err.Error())
+		// productions not sold in the visitor's currency are sold in the base currency
+		item, err = payWhatYouWantItem(production, defaultCurrency, amount)
+	}
+	if err != nil {
+		log.Printf("error on payHandler: %s", err)
+		http.Redirect(w, r, "/production/"+production.Slug, http.StatusSeeOther)
+		return
+	}
+
+	d := &pageData{
+		Title:             "Votre prix",
+		CurrentProduction: production,
+		LatestEpisodes:    latestEpisodes[0:3],
+		Total:             item.Price,
+	}
+	if err := render(w, r, "pay.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func freeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	purchase := Purchase{}
-	purchase.ProductionID = int(productionID)
-	purchase.Amount = p.CurrentPrice
-	purchase.ChargeID = ch.ID
-	purchase.Email = email
-	err = insertPurchase(purchase)
+	productionID, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
-		handleError(w, r, err.Error())
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
 	}
 
-	var emailData = new(struct {
-		Name  string
-		Title string
-		Token string
-	})
+	production, err := GetProduction(productionID, "")
+	if err != nil || !production.IsFreeWithEmail() {
+		log.Printf("error on freeHandler: production %d is not free with email", productionID)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !strings.Contains(email, "@") {
+		http.Redirect(w, r, "/production/"+production.Slug, http.StatusSeeOther)
+		return
+	}
+
+	items, err := productionItems([]*Production{production}, defaultCurrency)
+	if err == nil {
+		_, err = freeCheckout(r, email, items)
+	}
+	if err != nil {
+		log.Printf("error on freeHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	d := &pageData{Title: "Confirmation", LatestEpisodes: latestEpisodes[0:3]}
+	if err := render(w, r, "confirm.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func teamHandler(w http.ResponseWriter, r *http.Request) {
+	productionID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	production, err := GetProduction(productionID, "")
+	if err != nil {
+		log.Printf("error on teamHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	seats, _ := strconv.Atoi(r.FormValue("seats"))
+	item, err := teamLicenseItem(production, visitorCurrency(r), seats)
+	if err != nil {
+		// productions not sold in the visitor's currency are sold in the base currency
+		item, err = teamLicenseItem(production, defaultCurrency, seats)
+	}
+	if err != nil {
+		log.Printf("error on teamHandler: %s", err)
+		http.Redirect(w, r, "/production/"+production.Slug, http.StatusSeeOther)
+		return
+	}
+
+	d := &pageData{
+		Title:             "Licence d'équipe",
+		CurrentProduction: production,
+		LatestEpisodes:    latestEpisodes[0:3],
+		Seats:             seats,
+		MaxSeats:          maxSeats,
+		Total:             item.Price,
+	}
+	if err := render(w, r, "team.html", d); err != nil {
+		log.Println(err)
+	}
+}
 
-	emailData.Name = email
-	emailData.Title = p.Title
+func giftHandler(w http.ResponseWriter, r *http.Request) {
+	productionID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
 
-	key := fmt.Sprintf("%s|%d|%s", email, productionID, ch.ID)
-	emailData.Token = base64.URLEncoding.EncodeToString([]byte(key))
+	production, err := GetProduction(productionID, "")
+	if err != nil || production.CurrentPrice.IsZero() {
+		log.Printf("error on giftHandler: %v", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
 
-	var b bytes.Buffer
-	purchaseTmpl.Execute(&b, emailData)
+	if !production.setCurrency(visitorCurrency(r)) {
+		production.setCurrency(defaultCurrency)
+	}
 
-	sendMail(email, "Confirmation d'achat", b.String())
+	d := &pageData{
+		Title:             "Offrir en cadeau",
+		CurrentProduction: production,
+		LatestEpisodes:    latestEpisodes[0:3],
+	}
+	if err := render(w, r, "gift.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func licenseHandler(w http.ResponseWriter, r *http.Request) {
+	l, err := getTeamLicense(getID(r.URL.Path, "/license/"))
+	if err != nil {
+		log.Printf("error on licenseHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	d := &pageData{Title: "Licence d'équipe", LatestEpisodes: latestEpisodes[0:3], License: l}
+	if err := render(w, r, "license.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+// licenseSeatHandler assigns a seat of a team license to a teammate, or frees
+// it when no email is posted
+func licenseSeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	key := r.FormValue("token")
+	l, err := getTeamLicense(key)
+	if err != nil {
+		log.Printf("error on licenseSeatHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	seatID, _ := strconv.Atoi(r.FormValue("seat"))
+	seat := l.findSeat(seatID)
+	if seat == nil {
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if len(email) > 0 && !strings.Contains(email, "@") {
+		http.Redirect(w, r, "/license/"+key, http.StatusSeeOther)
+		return
+	}
+
+	if err := assignSeat(seat, email); err != nil {
+		log.Printf("error on licenseSeatHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	if len(email) > 0 {
+		sendSeatInvitation(l, seat)
+	}
+	http.Redirect(w, r, "/license/"+key, http.StatusSeeOther)
+}
+
+func cartHandler(w http.ResponseWriter, r *http.Request) {
+	cart, err := getCart(w, r)
+	if err != nil {
+		log.Printf("error on cartHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	d := &pageData{
+		Title:          "Mon panier",
+		LatestEpisodes: latestEpisodes[0:3],
+		Cart:           cart,
+		Currency:       visitorCurrency(r),
+		Currencies:     currencies,
+	}
+	if err := render(w, r, "cart.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func cartAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	productionID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	if err := addCartItem(cartID(w, r, true), productionID); err != nil {
+		log.Printf("error on cartAddHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+func cartRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	productionID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	if id := cartID(w, r, false); len(id) > 0 {
+		if err := removeCartItem(id, productionID); err != nil {
+			log.Printf("error on cartRemoveHandler: %s", err)
+			http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+			return
+		}
+	}
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+func cartCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart, err := getCart(w, r)
+	if err != nil || len(cart.Productions) == 0 {
+		log.Printf("error on cartCheckoutHandler: %v", err)
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	items, err := productionItems(cart.Productions, cart.Subtotal().Currency)
+	if err == nil {
+		_, err = checkout(r, items)
+	}
+	if err != nil {
+		log.Printf("error on cartCheckoutHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	if err := deleteCart(cart.ID); err != nil {
+		log.Printf("unable to delete cart %s: %s", cart.ID, err)
+	}
+	http.SetCookie(w, &http.Cookie{Name: cartCookie, Path: "/", MaxAge: -1})
 
 	d := &pageData{Title: "Confirmation d'achat", LatestEpisodes: latestEpisodes[0:3]}
-	if err := render(w, "confirm.html", d); err != nil {
+	if err := render(w, r, "confirm.html", d); err != nil {
 		log.Println(err)
 	}
 }
 
-func downloadHandler(w http.ResponseWriter, r *http.Request) {
-	key := getID(r.URL.Path, "/download/")
-	if len(key) == 0 {
+func subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		d := &pageData{Title: "Accès illimité", LatestEpisodes: latestEpisodes[0:3], Plans: subscriptionPlans}
+		if err := render(w, r, "subscribe.html", d); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	plan := findPlan(r.FormValue("plan"))
+	if plan == nil {
+		log.Println("Invalid subscription plan: " + r.FormValue("plan"))
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	email := r.FormValue("stripeEmail")
+	tax := taxPercent(r.FormValue("stripeBillingAddressCountryCode"), r.FormValue("stripeBillingAddressState"))
+	ps, err := payments.Subscribe(email, plan.ProviderPlan, r.FormValue("stripeToken"), tax)
+	if err != nil {
+		log.Printf("error on subscribeHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusBadRequest)
+		return
+	}
+
+	s := &Subscription{
+		Email:          email,
+		Plan:           plan.Code,
+		CustomerID:     ps.CustomerID,
+		SubscriptionID: ps.SubscriptionID,
+		Status:         ps.Status,
+		PeriodEnd:      ps.PeriodEnd,
+	}
+	if err := insertSubscription(s); err != nil {
+		// the webhook would not find the subscription without this row, it is
+		// cancelled so the customer is not billed again for an access we
+		// cannot give
+		log.Printf("unable to save subscription %s for %s: %s", ps.SubscriptionID, email, err)
+		if err := payments.CancelSubscription(ps.CustomerID, ps.SubscriptionID); err != nil {
+			log.Printf("unable to cancel unsaved subscription %s for %s, cancel it by hand: %s", ps.SubscriptionID, email, err)
+		}
+		http.Redirect(w, r, "/error", http.StatusInternalServerError)
+		return
+	}
+
+	sendSubscriptionConfirmation(s, plan)
+
+	setAccessCookie(w, s.accessToken())
+	http.Redirect(w, r, "/subscription/"+s.Token(), http.StatusSeeOther)
+}
+
+func subscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	key := getID(r.URL.Path, "/subscription/")
+	email, subscriptionID, err := parseSubscriptionToken(key)
+	if err != nil {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	b, err := base64.URLEncoding.DecodeString(key)
+	s, err := GetSubscription(email, subscriptionID)
 	if err != nil {
+		log.Printf("error on subscriptionHandler: %s", err)
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	parts := strings.Split(string(b), "|")
-	if len(parts) != 3 {
+	d := &pageData{Title: "Mon abonnement", LatestEpisodes: latestEpisodes[0:3], Subscription: s}
+	if s.IsActive() {
+		productions, err := GetProductions()
+		if err != nil {
+			log.Printf("error on subscriptionHandler: %s", err)
+			http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+			return
+		}
+
+		for _, p := range productions {
+			if !p.CurrentPrice.IsZero() {
+				d.Library = append(d.Library, &libraryItem{Production: p, Token: purchaseToken(email, p.ID, subscriptionID)})
+			}
+		}
+	}
+
+	setAccessCookie(w, s.accessToken())
+	if err := render(w, r, "subscription.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func subscriptionCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/subscribe", http.StatusSeeOther)
+		return
+	}
+
+	key := r.FormValue("token")
+	email, subscriptionID, err := parseSubscriptionToken(key)
+	if err != nil {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	prodID, err := strconv.ParseInt(parts[1], 10, 32)
+	s, err := GetSubscription(email, subscriptionID)
 	if err != nil {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	if err = increaseDownload(parts[0], int(prodID), parts[2]); err != nil {
+	if err := payments.CancelSubscription(s.CustomerID, s.SubscriptionID); err != nil {
+		log.Printf("error on subscriptionCancelHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	if err := setSubscriptionCancelRequested(s.ID); err != nil {
+		log.Printf("error on subscriptionCancelHandler: %s", err)
+	}
+	http.Redirect(w, r, "/subscription/"+key, http.StatusSeeOther)
+}
+
+func downloadHandler(w http.ResponseWriter, r *http.Request) {
+	key := getID(r.URL.Path, "/download/")
+	if len(key) == 0 {
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	email, prodID, chargeID, err := parsePurchaseToken(key)
+	if err != nil {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
 	}
 
-	data, err := ioutil.ReadFile(fmt.Sprintf("prods/%d.zip", prodID))
+	if !recordDownload(email, prodID, chargeID) {
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+	setAccessCookie(w, key)
+
+	data, err := ioutil.ReadFile(archivePath(prodID))
 	if err != nil {
 		http.Redirect(w, r, "/error", http.StatusNotFound)
 		return
@@ -296,6 +798,82 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, fmt.Sprintf("download/%d.zip", prodID), time.Now(), bytes.NewReader(data))
 }
 
+func invoiceHandler(w http.ResponseWriter, r *http.Request) {
+	key := getID(r.URL.Path, "/invoice/")
+	email, prodID, chargeID, err := parsePurchaseToken(key)
+	if err != nil {
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	purchase, err := GetPurchase(email, prodID, chargeID)
+	if err != nil {
+		log.Printf("error on invoiceHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	o, err := GetOrder(purchase.OrderID)
+	if err != nil {
+		log.Printf("error on invoiceHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	// no invoice is issued for free downloads
+	if o.Total().IsZero() {
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	inv, err := GetInvoice(o.ID)
+	if err == sql.ErrNoRows {
+		// orders made before invoices existed get their invoice on demand
+		inv, err = insertInvoice(o.ID)
+	}
+	if err != nil {
+		log.Printf("error on invoiceHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusInternalServerError)
+		return
+	}
+
+	data, err := generateInvoicePDF(inv, o)
+	if err != nil {
+		log.Printf("error on invoiceHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusExpectationFailed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename="+inv.Filename())
+	http.ServeContent(w, r, inv.Filename(), inv.IssuedOn, bytes.NewReader(data))
+}
+
+// purchaseToken returns the key identifying a purchase in download and invoice links
+func purchaseToken(email string, productionID int, chargeID string) string {
+	key := fmt.Sprintf("%s|%d|%s", email, productionID, chargeID)
+	return base64.URLEncoding.EncodeToString([]byte(key))
+}
+
+func parsePurchaseToken(token string) (email string, productionID int, chargeID string, err error) {
+	b, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 3 {
+		err = fmt.Errorf("invalid purchase token: %s", token)
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return
+	}
+	return parts[0], int(id), parts[2], nil
+}
+
 func getID(url string, controller string) string {
 	if len(url) < len(controller) || strings.ToUpper(url) == strings.ToUpper(controller) {
 		return ""
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Currency is a currency productions can be sold in
+type Currency struct {
+	Code   string
+	Symbol string
+	Name   string
+}
+
+const defaultCurrency = "CAD"
+
+var currencies = []Currency{
+	{Code: "CAD", Symbol: "$", Name: "Dollar canadien"},
+	{Code: "EUR", Symbol: "€", Name: "Euro"},
+	{Code: "USD", Symbol: "$ US", Name: "Dollar américain"},
+}
+
+// countries using the euro, used to guess the visitor currency
+var euroCountries = map[string]bool{
+	"AT": true, "BE": true, "CY": true, "DE": true, "EE": true, "ES": true,
+	"FI": true, "FR": true, "GR": true, "HR": true, "IE": true, "IT": true,
+	"LT": true, "LU": true, "LV": true, "MC": true, "MT": true, "NL": true,
+	"PT": true, "SI": true, "SK": true,
+}
+
+func findCurrency(code string) (Currency, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, c := range currencies {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return Currency{}, false
+}
+
+// formatMoney returns a French formatted amount, i.e. 1 299,99 €
+func formatMoney(cents int, code string) string {
+	c, ok := findCurrency(code)
+	if !ok {
+		c = Currency{Code: code, Symbol: code}
+	}
+
+	s := formatDecimal(cents, 2)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+
+	units, decimals := s[:len(s)-3], s[len(s)-2:]
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + " " + units[i:]
+	}
+	return sign + units + "," + decimals + " " + c.Symbol
+}
+
+// visitorCurrency returns the currency chosen by the visitor with the
+// currency switcher, or a guess based on the browser language
+func visitorCurrency(r *http.Request) string {
+	if c, err := r.Cookie("currency"); err == nil {
+		if cur, ok := findCurrency(c.Value); ok {
+			return cur.Code
+		}
+	}
+
+	for _, lang := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang = strings.TrimSpace(strings.Split(lang, ";")[0])
+		parts := strings.Split(lang, "-")
+		if len(parts) < 2 {
+			continue
+		}
+
+		region := strings.ToUpper(parts[len(parts)-1])
+		switch {
+		case region == "CA":
+			return "CAD"
+		case region == "US":
+			return "USD"
+		case euroCountries[region]:
+			return "EUR"
+		}
+	}
+	return defaultCurrency
+}
+
+func currencyHandler(w http.ResponseWriter, r *http.Request) {
+	if c, ok := findCurrency(r.URL.Query().Get("code")); ok {
+		http.SetCookie(w, &http.Cookie{
+			Name:    "currency",
+			Value:   c.Code,
+			Path:    "/",
+			Expires: time.Now().AddDate(1, 0, 0),
+		})
+	}
+
+	http.Redirect(w, r, refererPath(r), http.StatusFound)
+}
+
+// refererPath returns the path of the referring page to go back to, only on
+// our site, the home page otherwise
+func refererPath(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || (len(u.Host) > 0 && u.Host != r.Host) || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || strings.Contains(u.Path, "\\") {
+		return "/"
+	}
+	if len(u.RawQuery) > 0 {
+		return u.Path + "?" + u.RawQuery
+	}
+	return u.Path
+}
+
+// setCurrency switches the production prices to the currency, it returns false
+// when the production is not sold in that currency
+func (p *Production) setCurrency(code string) bool {
+	if code == p.CurrentPrice.Currency {
+		return true
+	}
+
+	if code == defaultCurrency {
+		p.ListPrice = p.Price
+		p.CurrentPrice = p.Price
+		if p.SalesPrice.Amount > 0 {
+			p.CurrentPrice = p.SalesPrice
+		}
+		p.applyDiscounts()
+		return true
+	}
+
+	for _, price := range p.Prices {
+		if price.Currency == code {
+			p.ListPrice = Money{Amount: price.Price, Currency: code}
+			p.CurrentPrice = p.ListPrice
+			if price.SalesPrice > 0 {
+				p.CurrentPrice = Money{Amount: price.SalesPrice, Currency: code}
+			}
+			p.applyDiscounts()
+			return true
+		}
+	}
+	return false
+}
+
+// OnSale returns true when the production is sold below its regular price
+func (p *Production) OnSale() bool {
+	return p.CurrentPrice.Less(p.ListPrice)
+}
@@ -35,38 +35,100 @@ type EpisodeOverview struct {
 	Slug           string
 	ProductionSlug string
 	ReleasedOn     time.Time
-	Price          float32
+	Price          Money
 	ProductionID   int
 }
 
 // Production represents a video series, containing multiple episodes
 type Production struct {
-	ID                 int           `json:"id"`
-	Slug               string        `json:"slug"`
-	Title              string        `json:"title"`
-	Description        string        `json:"desc"`
-	DescriptionHTML    template.HTML `json:"descHtml"`
-	DescriptionExcerpt string        `json:"descExcerpt"`
-	PresentationText   string        `json:"presentationText"`
-	PresentationHTML   template.HTML `json:"presentationHtml"`
-	Price              float32       `json:"price"`
-	SalesPrice         float32       `json:"salesPrice"`
-	CurrentPrice       int           `json:"currentPrice"`
-	Status             string        `json:"status"`
-	ProductionType     string        `json:"productionType"`
-	Author             string        `json:"author"`
-	ReleasedOn         time.Time     `json:"releasedO"`
-	YoutubePreview     string        `json:"youtubePreview"`
-	IsFeatured         bool          `json:"isFeatured"`
-	DownloadLink       *string       `json:"downloadLink"`
-	Category           string        `json:"category"`
-	Tags               string        `json:"tags"`
+	ID                 int                `json:"id"`
+	Slug               string             `json:"slug"`
+	Title              string             `json:"title"`
+	Description        string             `json:"desc"`
+	DescriptionHTML    template.HTML      `json:"descHtml"`
+	DescriptionExcerpt string             `json:"descExcerpt"`
+	PresentationText   string             `json:"presentationText"`
+	PresentationHTML   template.HTML      `json:"presentationHtml"`
+	Price              Money              `json:"price"`
+	SalesPrice         Money              `json:"salesPrice"`
+	CurrentPrice       Money              `json:"currentPrice"`
+	ListPrice          Money              `json:"listPrice"`
+	Prices             []*ProductionPrice `json:"prices"`
+	Sale               *Sale              `json:"sale"`
+	PreorderPrice      Money              `json:"preorderPrice"`
+	PricingMode        string             `json:"pricingMode"`
+	Status             string             `json:"status"`
+	ProductionType     string             `json:"productionType"`
+	Author             string             `json:"author"`
+	ReleasedOn         time.Time          `json:"releasedO"`
+	YoutubePreview     string             `json:"youtubePreview"`
+	IsFeatured         bool               `json:"isFeatured"`
+	DownloadLink       *string            `json:"downloadLink"`
+	Category           string             `json:"category"`
+	Tags               string             `json:"tags"`
 	Episodes           []*Episode
 	EpisodeCount       int
 	SingleEpisode      bool
 	EpisodesDuration   int
 }
 
+// ProductionPrice is the price of a production in an additional currency, in cents
+type ProductionPrice struct {
+	Currency   string `json:"currency"`
+	Price      int    `json:"price"`
+	SalesPrice int    `json:"salesPrice"`
+}
+
+// Bundle is a set of productions sold together at a discount
+type Bundle struct {
+	ID            int           `json:"id"`
+	Slug          string        `json:"slug"`
+	Title         string        `json:"title"`
+	Description   string        `json:"desc"`
+	DescHTML      template.HTML `json:"descHtml"`
+	Price         Money         `json:"price"`
+	IsActive      bool          `json:"isActive"`
+	ProductionIDs []int         `json:"productionIds"`
+	Productions   []*Production `json:"-"`
+}
+
+// Sale is a time-boxed discount on productions, either a percentage off or a
+// fixed price in the base currency
+type Sale struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	StartsOn      time.Time `json:"startsOn"`
+	EndsOn        time.Time `json:"endsOn"`
+	Percent       int       `json:"percent"`
+	Price         Money     `json:"price"`
+	ProductionIDs []int     `json:"productionIds"`
+}
+
+// PriceRecord is the price paid for a purchase and why
+type PriceRecord struct {
+	ID           int       `json:"id"`
+	PurchaseID   int       `json:"purchaseId"`
+	ProductionID int       `json:"productionId"`
+	ListPrice    Money     `json:"listPrice"`
+	PaidPrice    Money     `json:"paidPrice"`
+	SaleID       int       `json:"saleId"`
+	Reason       string    `json:"reason"`
+	RecordedOn   time.Time `json:"recordedOn"`
+}
+
+// Subscription is an all-access subscription unlocking every paid production
+type Subscription struct {
+	ID              int       `json:"id"`
+	Email           string    `json:"email"`
+	Plan            string    `json:"plan"`
+	CustomerID      string    `json:"customerId"`
+	SubscriptionID  string    `json:"subscriptionId"`
+	Status          string    `json:"status"`
+	PeriodEnd       time.Time `json:"periodEnd"`
+	CreatedOn       time.Time `json:"createdOn"`
+	CancelRequested bool      `json:"cancelRequested"`
+}
+
 // Post represents a blog post
 type Post struct {
 	ID         int
@@ -87,12 +149,184 @@ type Post struct {
 // Purchase represent a customer buying a production
 type Purchase struct {
 	ID            int       `json:"id"`
+	OrderID       string    `json:"orderId"`
 	ProductionID  int       `json:"productionId"`
+	BundleID      int       `json:"bundleId"`
 	Email         string    `json:"email"`
-	Amount        int       `json:"amount"`
+	Subtotal      Money     `json:"subtotal"`
+	Taxes         Taxes     `json:"taxes"`
+	Amount        Money     `json:"amount"`
 	ChargeID      string    `json:"chargeId"`
 	PurchasedDate time.Time `json:"purchasedDate"`
 	Downloaded    int       `json:"downloaded"`
+	Seats         int       `json:"seats"`
+	IsGift        bool      `json:"isGift"`
+	AffiliateID   int       `json:"affiliateId"`
+	Commission    Money     `json:"commission"`
+	Refunded      Money     `json:"refunded"`
+	Preorder      string    `json:"preorder"`
+	Undelivered   bool      `json:"emailUndelivered"`
+	MessageID     string    `json:"confirmationMessageId"`
+	// Fees is the processing fee reported by the payment provider, nil for
+	// the purchases made before it was recorded
+	Fees *Money `json:"fees"`
+}
+
+// Refund is a part of a purchase refunded, taxes included, booked the day it
+// was made
+type Refund struct {
+	ID         int       `json:"id"`
+	PurchaseID int       `json:"purchaseId"`
+	Amount     Money     `json:"amount"`
+	RefundedOn time.Time `json:"refundedOn"`
+}
+
+// Instructor is a guest instructor paid a share of the net revenue of their
+// productions
+type Instructor struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	SharePercent  int       `json:"sharePercent"`
+	CreatedOn     time.Time `json:"createdOn"`
+	ProductionIDs []int     `json:"productionIds"`
+}
+
+// Royalty is the share of a purchase owed to an instructor
+type Royalty struct {
+	ID           int       `json:"id"`
+	PurchaseID   int       `json:"purchaseId"`
+	InstructorID int       `json:"instructorId"`
+	Refunded     Money     `json:"refunded"`
+	Fees         Money     `json:"fees"`
+	Net          Money     `json:"net"`
+	SharePercent int       `json:"sharePercent"`
+	Amount       Money     `json:"amount"`
+	CalculatedOn time.Time `json:"calculatedOn"`
+}
+
+// Affiliate is a partner earning a commission on the sales made through its
+// referral code
+type Affiliate struct {
+	ID                int       `json:"id"`
+	Code              string    `json:"code"`
+	Name              string    `json:"name"`
+	Email             string    `json:"email"`
+	CommissionPercent int       `json:"commissionPercent"`
+	IsActive          bool      `json:"isActive"`
+	CreatedOn         time.Time `json:"createdOn"`
+}
+
+// Seat is a seat of a team license, assigned to a teammate by the purchaser
+type Seat struct {
+	ID         int    `json:"id"`
+	PurchaseID int    `json:"purchaseId"`
+	Email      string `json:"email"`
+	Token      string `json:"token"`
+	Downloaded int    `json:"downloaded"`
+}
+
+// Gift is a production bought for someone else, emailed on its delivery date
+type Gift struct {
+	ID             int        `json:"id"`
+	PurchaseID     int        `json:"purchaseId"`
+	ProductionID   int        `json:"productionId"`
+	FromEmail      string     `json:"fromEmail"`
+	RecipientEmail string     `json:"recipientEmail"`
+	Message        string     `json:"message"`
+	DeliverOn      time.Time  `json:"deliverOn"`
+	Token          string     `json:"token"`
+	SentOn         *time.Time `json:"sentOn"`
+	Downloaded     int        `json:"downloaded"`
+}
+
+// OutboxEmail is an outgoing email waiting in the outbox, sent or dead once
+// all its delivery attempts failed
+type OutboxEmail struct {
+	ID            int        `json:"id"`
+	From          string     `json:"from"`
+	To            string     `json:"to"`
+	ReplyTo       string     `json:"replyTo"`
+	Subject       string     `json:"subject"`
+	HTML          string     `json:"-"`
+	Text          string     `json:"-"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptOn time.Time  `json:"nextAttemptOn"`
+	LastError     string     `json:"lastError"`
+	CreatedOn     time.Time  `json:"createdOn"`
+	SentOn        *time.Time `json:"sentOn"`
+	Headers       string     `json:"-"`
+	MessageID     string     `json:"messageId"`
+}
+
+// Subscriber is an email on the mailing list, confirmed by double opt-in
+type Subscriber struct {
+	ID             int        `json:"id"`
+	Email          string     `json:"email"`
+	Status         string     `json:"status"`
+	Source         string     `json:"source"`
+	CreatedOn      time.Time  `json:"createdOn"`
+	ConfirmedOn    *time.Time `json:"confirmedOn"`
+	UnsubscribedOn *time.Time `json:"unsubscribedOn"`
+}
+
+// DripCourse is a free mini-course emailed step by step, ending with an offer
+// for its production
+type DripCourse struct {
+	ID           int         `json:"id"`
+	Slug         string      `json:"slug"`
+	Title        string      `json:"title"`
+	Description  string      `json:"desc"`
+	ProductionID int         `json:"productionId"`
+	IsActive     bool        `json:"isActive"`
+	Steps        []*DripStep `json:"steps"`
+}
+
+// DripStep is an email of a drip course sent DelayDays after the enrollment
+type DripStep struct {
+	Position  int    `json:"position"`
+	DelayDays int    `json:"delayDays"`
+	Template  string `json:"template"`
+}
+
+// DripEnrollment is an email following a drip course
+type DripEnrollment struct {
+	ID         int        `json:"id"`
+	CourseID   int        `json:"courseId"`
+	Email      string     `json:"email"`
+	EnrolledOn time.Time  `json:"enrolledOn"`
+	NextStep   int        `json:"nextStep"`
+	Status     string     `json:"status"`
+	StoppedOn  *time.Time `json:"stoppedOn"`
+}
+
+// Suppression is an address we no longer email, Reason is bounce or complaint
+type Suppression struct {
+	Email        string    `json:"email"`
+	Reason       string    `json:"reason"`
+	Detail       string    `json:"detail"`
+	SuppressedOn time.Time `json:"suppressedOn"`
+}
+
+// ContactMessage is a message sent with the contact form
+type ContactMessage struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	IP        string    `json:"ip"`
+	CreatedOn time.Time `json:"createdOn"`
+}
+
+// Customer is the account of a customer, signed in with a link emailed to
+// them
+type Customer struct {
+	ID          int        `json:"id"`
+	Email       string     `json:"email"`
+	CreatedOn   time.Time  `json:"createdOn"`
+	LastLoginOn *time.Time `json:"lastLoginOn"`
 }
 
 func openConnection() error {
@@ -141,9 +375,10 @@ func readEpisodeOverview(rows *sql.Rows) (*EpisodeOverview, error) {
 		&e.Slug,
 		&e.ProductionSlug,
 		&e.ReleasedOn,
-		&e.Price,
+		&e.Price.Amount,
 		&e.ProductionID,
 	)
+	e.Price.Currency = defaultCurrency
 	return &e, err
 }
 
@@ -154,18 +389,20 @@ func readProduction(rows *sql.Rows) (*Production, error) {
 		&prod.Slug,
 		&prod.Title,
 		&prod.Description,
-		&prod.Price,
+		&prod.Price.Amount,
 		&prod.Status,
 		&prod.ProductionType,
 		&prod.Author,
 		&prod.ReleasedOn,
 		&prod.YoutubePreview,
 		&prod.DownloadLink,
-		&prod.SalesPrice,
+		&prod.SalesPrice.Amount,
 		&prod.IsFeatured,
 		&prod.PresentationText,
 		&prod.Category,
-		&prod.Tags)
+		&prod.Tags,
+		&prod.PreorderPrice.Amount,
+		&prod.PricingMode)
 
 	prod.DescriptionHTML = template.HTML(prod.Description)
 	prod.PresentationHTML = template.HTML(prod.PresentationText)
@@ -175,10 +412,15 @@ func readProduction(rows *sql.Rows) (*Production, error) {
 		prod.DescriptionExcerpt = prod.DescriptionExcerpt[:200] + "..."
 	}
 
-	prod.CurrentPrice = int(prod.Price * 100)
-	if prod.SalesPrice > 0 {
-		prod.CurrentPrice = int(prod.SalesPrice * 100)
+	prod.Price.Currency = defaultCurrency
+	prod.SalesPrice.Currency = defaultCurrency
+	prod.PreorderPrice.Currency = defaultCurrency
+	prod.ListPrice = prod.Price
+	prod.CurrentPrice = prod.Price
+	if prod.SalesPrice.Amount > 0 {
+		prod.CurrentPrice = prod.SalesPrice
 	}
+	prod.applyPreorder()
 
 	return &prod, err
 }
@@ -220,6 +462,13 @@ func GetFeatured() (*Production, error) {
 		}
 		prod = p
 	}
+
+	if prod.ID > 0 {
+		err = loadPricing(prod)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return prod, nil
 }
 
@@ -237,6 +486,39 @@ func GetCollection(category string) ([]*Production, error) {
 	}
 	defer rows.Close()
 
+	var productions []*Production
+	for rows.Next() {
+		p, err := readProduction(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		productions = append(productions, p)
+	}
+
+	for _, p := range productions {
+		if err := loadPricing(p); err != nil {
+			return nil, err
+		}
+	}
+	return productions, nil
+}
+
+// GetProductions returns all productions, latest first, without their episodes
+// nor their additional currency prices
+func GetProductions() ([]*Production, error) {
+	sql, err := db.Prepare("SELECT * FROM Productions ORDER BY ReleasedOn DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	var productions []*Production
 	for rows.Next() {
 		p, err := readProduction(rows)
@@ -306,16 +588,21 @@ func GetProduction(id int, slug string) (*Production, error) {
 			episodes = append(episodes, e)
 		}
 
+		err = loadPricing(production)
+		if err != nil {
+			return nil, err
+		}
+
 		production.Episodes = episodes
 		production.EpisodeCount = len(episodes)
 		production.SingleEpisode = production.EpisodeCount == 1
 
-    mins := 0
-    for _, e := range production.Episodes {
-      mins += e.Minutes
-    }
+		mins := 0
+		for _, e := range production.Episodes {
+			mins += e.Minutes
+		}
 
-    production.EpisodesDuration = mins
+		production.EpisodesDuration = mins
 
 		return production, nil
 	}
@@ -389,7 +676,7 @@ func getLatestEpisodes() ([]*EpisodeOverview, error) {
 }
 
 func insertProduction(prod *Production) (int64, error) {
-	sql, err := db.Prepare("INSERT INTO Productions VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	sql, err := db.Prepare("INSERT INTO Productions VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return 0, err
 	}
@@ -398,18 +685,20 @@ func insertProduction(prod *Production) (int64, error) {
 	r, err := sql.Exec(prod.Slug,
 		prod.Title,
 		prod.Description,
-		prod.Price,
+		prod.Price.Amount,
 		prod.Status,
 		prod.ProductionType,
 		prod.Author,
 		time.Now(),
 		prod.YoutubePreview,
 		prod.DownloadLink,
-		prod.SalesPrice,
+		prod.SalesPrice.Amount,
 		prod.IsFeatured,
 		prod.PresentationText,
 		prod.Category,
 		prod.Tags,
+		prod.PreorderPrice.Amount,
+		prod.PricingMode,
 	)
 
 	if err != nil {
@@ -435,7 +724,9 @@ func updateProduction(prod *Production) error {
     IsFeatured = ?,
     PresentationText = ?,
     Category = ?,
-    Tags = ?
+    Tags = ?,
+    PreorderPrice = ?,
+    PricingMode = ?
   WHERE ID = ?
   `)
 	if err != nil {
@@ -446,32 +737,90 @@ func updateProduction(prod *Production) error {
 	_, err = sql.Exec(prod.Slug,
 		prod.Title,
 		prod.Description,
-		prod.Price,
+		prod.Price.Amount,
 		prod.Status,
 		prod.ProductionType,
 		prod.Author,
 		prod.ReleasedOn,
 		prod.YoutubePreview,
 		prod.DownloadLink,
-		prod.SalesPrice,
+		prod.SalesPrice.Amount,
 		prod.IsFeatured,
 		prod.PresentationText,
 		prod.Category,
 		prod.Tags,
+		prod.PreorderPrice.Amount,
+		prod.PricingMode,
 		prod.ID,
 	)
 
 	return err
 }
 
+func getProductionPrices(productionID int) ([]*ProductionPrice, error) {
+	sql, err := db.Prepare("SELECT Currency, Price, SalesPrice FROM ProductionPrices WHERE ProductionID = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(productionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []*ProductionPrice
+	for rows.Next() {
+		p := ProductionPrice{}
+		if err := rows.Scan(&p.Currency, &p.Price, &p.SalesPrice); err != nil {
+			return nil, err
+		}
+		prices = append(prices, &p)
+	}
+	return prices, nil
+}
+
+// saveProductionPrices replaces the additional currency prices of a production
+func saveProductionPrices(productionID int, prices []*ProductionPrice) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM ProductionPrices WHERE ProductionID = ?", productionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, p := range prices {
+		if _, ok := findCurrency(p.Currency); !ok || strings.ToUpper(p.Currency) == defaultCurrency {
+			tx.Rollback()
+			return fmt.Errorf("unsupported additional currency: %s", p.Currency)
+		}
+
+		_, err := tx.Exec("INSERT INTO ProductionPrices (ProductionID, Currency, Price, SalesPrice) VALUES(?, ?, ?, ?)",
+			productionID, strings.ToUpper(p.Currency), p.Price, p.SalesPrice)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func insertEpisode(e *Episode) (int64, error) {
-	sql, err := db.Prepare("INSERT INTO Episodes VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	sql, err := db.Prepare(`INSERT INTO Episodes
+    (ProductionID, Title, Description, ReleasedOn, Duration, Slug, YoutubeURL, Minutes)
+  OUTPUT INSERTED.ID
+  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return -1, err
 	}
 	defer sql.Close()
 
-	r, err := sql.Exec(e.ProductionID,
+	var id int64
+	err = sql.QueryRow(e.ProductionID,
 		e.Title,
 		e.Description,
 		e.ReleasedOn,
@@ -479,12 +828,13 @@ func insertEpisode(e *Episode) (int64, error) {
 		e.Slug,
 		e.YoutubeURL,
 		e.Minutes,
-	)
+	).Scan(&id)
 	if err != nil {
 		return -1, err
 	}
 
-	return r.LastInsertId()
+	e.ID = int(id)
+	return id, nil
 }
 
 func updateEpisode(e *Episode) error {
@@ -503,8 +853,7 @@ func updateEpisode(e *Episode) error {
 	}
 	defer sql.Close()
 
-	_, err = sql.Exec(e.ProductionID,
-		e.Title,
+	_, err = sql.Exec(e.Title,
 		e.Description,
 		e.ReleasedOn,
 		e.Duration,
@@ -517,19 +866,194 @@ func updateEpisode(e *Episode) error {
 	return err
 }
 
-func insertPurchase(p Purchase) error {
-	sql, err := db.Prepare("INSERT INTO Purchases VALUES(?, ?, ?, ?, ?, ?)")
+func readPurchase(rows *sql.Rows) (*Purchase, error) {
+	p := Purchase{}
+	var fees *int
+	err := rows.Scan(
+		&p.ID,
+		&p.ProductionID,
+		&p.Email,
+		&p.Amount.Amount,
+		&p.ChargeID,
+		&p.PurchasedDate,
+		&p.Downloaded,
+		&p.Subtotal.Amount,
+		&p.Taxes.Country,
+		&p.Taxes.Province,
+		&p.Taxes.GST,
+		&p.Taxes.HST,
+		&p.Taxes.PST,
+		&p.Taxes.QST,
+		&p.Amount.Currency,
+		&p.OrderID,
+		&p.BundleID,
+		&p.Seats,
+		&p.IsGift,
+		&p.AffiliateID,
+		&p.Commission.Amount,
+		&p.Refunded.Amount,
+		&p.Preorder,
+		&p.Undelivered,
+		&p.MessageID,
+		&fees,
+	)
+	p.Subtotal.Currency = p.Amount.Currency
+	if fees != nil {
+		p.Fees = &Money{Amount: *fees, Currency: p.Amount.Currency}
+	}
+	p.Commission.Currency = p.Amount.Currency
+	p.Refunded.Currency = p.Amount.Currency
+	return &p, err
+}
+
+// GetPurchase returns the purchase matching a download token parts
+func GetPurchase(email string, productionID int, chargeID string) (*Purchase, error) {
+	sql, err := db.Prepare("SELECT * FROM Purchases WHERE Email = ? AND ProductionID = ? AND ChargeID = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(email, productionID, chargeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return readPurchase(rows)
+	}
+	return nil, errors.New("Purchase not found")
+}
+
+// GetOrder returns all purchases paid together with their production title
+func GetOrder(orderID string) (*Order, error) {
+	sql, err := db.Prepare("SELECT * FROM Purchases WHERE OrderID = ? ORDER BY ID")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	o := &Order{ID: orderID}
+	for rows.Next() {
+		p, err := readPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		o.Email = p.Email
+		o.ChargeID = p.ChargeID
+		o.PurchasedDate = p.PurchasedDate
+		o.Lines = append(o.Lines, &OrderLine{Purchase: p})
+	}
+
+	if len(o.Lines) == 0 {
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+
+	for _, l := range o.Lines {
+		prod, err := GetProduction(l.Purchase.ProductionID, "")
+		if err != nil {
+			return nil, err
+		}
+		l.Title = licenseTitle(prod.Title, l.Purchase.Seats)
+	}
+	return o, nil
+}
+
+// GetInvoice returns the invoice issued for an order, sql.ErrNoRows when
+// none was issued
+func GetInvoice(orderID string) (*Invoice, error) {
+	i := Invoice{}
+	err := db.QueryRow("SELECT Number, OrderID, IssuedOn FROM Invoices WHERE OrderID = ?", orderID).Scan(&i.Number, &i.OrderID, &i.IssuedOn)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// insertPurchase saves the purchase and sets its ID and purchased date
+func insertPurchase(p *Purchase) error {
+	sql, err := db.Prepare(`INSERT INTO Purchases
+    (ProductionID, Email, Amount, ChargeID, PurchasedDate, Downloaded, Subtotal, Country, Province, GST, HST, PST, QST, Currency, OrderID, BundleID, Seats, IsGift, AffiliateID, Commission, PreorderStatus, ConfirmationMessageID, PaymentFees)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer sql.Close()
 
-	_, err = sql.Exec(p.ProductionID, p.Email, p.Amount, p.ChargeID, time.Now(), 0)
+	if p.Seats < 1 {
+		p.Seats = 1
+	}
+
+	var fees interface{}
+	if p.Fees != nil {
+		fees = p.Fees.Amount
+	}
+
+	p.PurchasedDate = time.Now()
+	err = sql.QueryRow(p.ProductionID,
+		p.Email,
+		p.Amount.Amount,
+		p.ChargeID,
+		p.PurchasedDate,
+		0,
+		p.Subtotal.Amount,
+		p.Taxes.Country,
+		p.Taxes.Province,
+		p.Taxes.GST,
+		p.Taxes.HST,
+		p.Taxes.PST,
+		p.Taxes.QST,
+		p.Amount.Currency,
+		p.OrderID,
+		p.BundleID,
+		p.Seats,
+		p.IsGift,
+		p.AffiliateID,
+		p.Commission.Amount,
+		p.Preorder,
+		p.MessageID,
+		fees,
+	).Scan(&p.ID)
 	return err
 }
 
+// insertInvoice issues the next invoice number for an order, the counter is
+// updated in the same transaction so the numbers have no gaps
+func insertInvoice(orderID string) (*Invoice, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Invoice{OrderID: orderID, IssuedOn: time.Now()}
+	err = tx.QueryRow("UPDATE InvoiceNumbers SET LastNumber = LastNumber + 1 OUTPUT INSERTED.LastNumber WHERE ID = 1").Scan(&i.Number)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO Invoices (Number, OrderID, IssuedOn) VALUES(?, ?, ?)", i.Number, i.OrderID, i.IssuedOn); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
 func increaseDownload(email string, productionID int, chargeID string) error {
-	sql, err := db.Prepare("UPDATE Purchases SET Downloaded = Downloaded + 1 WHERE Email = ? AND ProductionID = ? AND ChargeID = ?")
+	sql, err := db.Prepare("UPDATE Purchases SET Downloaded = Downloaded + 1 WHERE Email = ? AND ProductionID = ? AND ChargeID = ? AND Seats = 1 AND IsGift = 0")
 	if err != nil {
 		return err
 	}
@@ -546,3 +1070,1908 @@ func increaseDownload(email string, productionID int, chargeID string) error {
 	}
 	return nil
 }
+
+func readBundle(rows *sql.Rows) (*Bundle, error) {
+	b := Bundle{}
+	err := rows.Scan(
+		&b.ID,
+		&b.Slug,
+		&b.Title,
+		&b.Description,
+		&b.Price.Amount,
+		&b.IsActive,
+	)
+	b.Price.Currency = defaultCurrency
+	b.DescHTML = template.HTML(b.Description)
+	return &b, err
+}
+
+// GetBundles returns all bundles, inactive ones included
+func GetBundles() ([]*Bundle, error) {
+	sql, err := db.Prepare("SELECT * FROM Bundles ORDER BY Title")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bundles []*Bundle
+	for rows.Next() {
+		b, err := readBundle(rows)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, b)
+	}
+	return bundles, nil
+}
+
+// GetBundle returns a bundle by id or slug with its productions
+func GetBundle(id int, slug string) (*Bundle, error) {
+	var wc string
+	var p interface{}
+	if id > 0 {
+		wc, p = "ID", id
+	} else {
+		wc, p = "Slug", slug
+	}
+
+	sql, err := db.Prepare(strings.Replace("SELECT * FROM Bundles WHERE _ = ?", "_", wc, -1))
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(p)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, fmt.Errorf("bundle not found: %v", p)
+	}
+
+	b, err := readBundle(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	subSQL, err := db.Prepare("SELECT ProductionID FROM BundleProductions WHERE BundleID = ? ORDER BY ProductionID")
+	if err != nil {
+		return nil, err
+	}
+	defer subSQL.Close()
+
+	subRows, err := subSQL.Query(b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer subRows.Close()
+
+	for subRows.Next() {
+		var prodID int
+		if err := subRows.Scan(&prodID); err != nil {
+			return nil, err
+		}
+		b.ProductionIDs = append(b.ProductionIDs, prodID)
+	}
+
+	for _, prodID := range b.ProductionIDs {
+		prod, err := GetProduction(prodID, "")
+		if err != nil {
+			return nil, err
+		}
+		b.Productions = append(b.Productions, prod)
+	}
+	return b, nil
+}
+
+func insertBundle(b *Bundle) (int, error) {
+	sql, err := db.Prepare("INSERT INTO Bundles (Slug, Title, Description, Price, IsActive) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer sql.Close()
+
+	var id int
+	if err := sql.QueryRow(b.Slug, b.Title, b.Description, b.Price.Amount, b.IsActive).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, saveBundleProductions(id, b.ProductionIDs)
+}
+
+func updateBundle(b *Bundle) error {
+	sql, err := db.Prepare(`UPDATE Bundles SET
+    Slug = ?,
+    Title = ?,
+    Description = ?,
+    Price = ?,
+    IsActive = ?
+  WHERE ID = ?
+  `)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	_, err = sql.Exec(b.Slug, b.Title, b.Description, b.Price.Amount, b.IsActive, b.ID)
+	if err != nil {
+		return err
+	}
+	return saveBundleProductions(b.ID, b.ProductionIDs)
+}
+
+func deleteBundle(id int) error {
+	_, err := db.Exec("DELETE FROM Bundles WHERE ID = ?", id)
+	return err
+}
+
+// saveBundleProductions replaces the productions included in a bundle
+func saveBundleProductions(bundleID int, productionIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM BundleProductions WHERE BundleID = ?", bundleID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, id := range productionIDs {
+		if _, err := tx.Exec("INSERT INTO BundleProductions (BundleID, ProductionID) VALUES(?, ?)", bundleID, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// hasPurchased returns true if the email bought the production for itself,
+// was assigned a seat of a team license or received it as a gift
+func hasPurchased(email string, productionID int) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT
+    (SELECT COUNT(*) FROM Purchases WHERE Email = ? AND ProductionID = ? AND Seats = 1 AND IsGift = 0) +
+    (SELECT COUNT(*) FROM LicenseSeats s INNER JOIN Purchases p ON p.ID = s.PurchaseID WHERE s.Email = ? AND p.ProductionID = ?) +
+    (SELECT COUNT(*) FROM Gifts WHERE RecipientEmail = ? AND ProductionID = ? AND SentOn IS NOT NULL)`,
+		email, productionID, email, productionID, email, productionID).Scan(&count)
+	return count > 0, err
+}
+
+func readSubscription(rows *sql.Rows) (*Subscription, error) {
+	s := Subscription{}
+	err := rows.Scan(
+		&s.ID,
+		&s.Email,
+		&s.Plan,
+		&s.CustomerID,
+		&s.SubscriptionID,
+		&s.Status,
+		&s.PeriodEnd,
+		&s.CreatedOn,
+		&s.CancelRequested,
+	)
+	return &s, err
+}
+
+func querySubscriptions(qry string, args ...interface{}) ([]*Subscription, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*Subscription
+	for rows.Next() {
+		s, err := readSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+// GetSubscription returns the subscription of an email by its provider id
+func GetSubscription(email, subscriptionID string) (*Subscription, error) {
+	subs, err := querySubscriptions("SELECT * FROM Subscriptions WHERE Email = ? AND SubscriptionID = ?", email, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(subs) == 0 {
+		return nil, errors.New("Subscription not found")
+	}
+	return subs[0], nil
+}
+
+// GetSubscriptions returns all subscriptions of an email, latest first
+func GetSubscriptions(email string) ([]*Subscription, error) {
+	return querySubscriptions("SELECT * FROM Subscriptions WHERE Email = ? ORDER BY CreatedOn DESC", email)
+}
+
+func insertSubscription(s *Subscription) error {
+	sql, err := db.Prepare(`INSERT INTO Subscriptions
+    (Email, [Plan], CustomerID, SubscriptionID, Status, PeriodEnd, CreatedOn, CancelRequested)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	s.CreatedOn = time.Now()
+	return sql.QueryRow(s.Email,
+		s.Plan,
+		s.CustomerID,
+		s.SubscriptionID,
+		s.Status,
+		s.PeriodEnd,
+		s.CreatedOn,
+		s.CancelRequested,
+	).Scan(&s.ID)
+}
+
+// updateSubscription saves the subscription state notified by the payment provider
+func updateSubscription(ps providerSubscription) error {
+	_, err := db.Exec("UPDATE Subscriptions SET Status = ?, PeriodEnd = ? WHERE SubscriptionID = ?",
+		ps.Status, ps.PeriodEnd, ps.SubscriptionID)
+	return err
+}
+
+func setSubscriptionCancelRequested(id int) error {
+	_, err := db.Exec("UPDATE Subscriptions SET CancelRequested = 1 WHERE ID = ?", id)
+	return err
+}
+
+func readSeat(rows *sql.Rows) (*Seat, error) {
+	s := Seat{}
+	err := rows.Scan(
+		&s.ID,
+		&s.PurchaseID,
+		&s.Email,
+		&s.Token,
+		&s.Downloaded,
+	)
+	return &s, err
+}
+
+// GetSeats returns the seats of a team license
+func GetSeats(purchaseID int) ([]*Seat, error) {
+	sql, err := db.Prepare("SELECT * FROM LicenseSeats WHERE PurchaseID = ? ORDER BY ID")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []*Seat
+	for rows.Next() {
+		s, err := readSeat(rows)
+		if err != nil {
+			return nil, err
+		}
+		seats = append(seats, s)
+	}
+	return seats, nil
+}
+
+// GetSeat returns the seat matching a download token parts
+func GetSeat(email string, productionID int, token string) (*Seat, error) {
+	sql, err := db.Prepare(`SELECT s.* FROM LicenseSeats s INNER JOIN Purchases p ON p.ID = s.PurchaseID
+  WHERE s.Email = ? AND s.Email <> '' AND s.Token = ? AND p.ProductionID = ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(email, token, productionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return readSeat(rows)
+	}
+	return nil, errors.New("Seat not found")
+}
+
+// insertSeats creates the unassigned seats of a team license
+func insertSeats(purchaseID, seats int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < seats; i++ {
+		if _, err := tx.Exec("INSERT INTO LicenseSeats (PurchaseID, Token) VALUES(?, ?)", purchaseID, randomToken(16)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// assignSeat gives the seat to an email, a new token is issued so the links
+// sent to the previous teammate stop working
+func assignSeat(s *Seat, email string) error {
+	token := randomToken(16)
+	_, err := db.Exec("UPDATE LicenseSeats SET Email = ?, Token = ?, Downloaded = 0 WHERE ID = ?", email, token, s.ID)
+	if err != nil {
+		return err
+	}
+
+	s.Email, s.Token, s.Downloaded = email, token, 0
+	return nil
+}
+
+func increaseSeatDownload(email string, productionID int, token string) error {
+	r, err := db.Exec(`UPDATE s SET Downloaded = s.Downloaded + 1
+  FROM LicenseSeats s INNER JOIN Purchases p ON p.ID = s.PurchaseID
+  WHERE s.Email = ? AND s.Email <> '' AND s.Token = ? AND p.ProductionID = ?`, email, token, productionID)
+	if err != nil {
+		return err
+	}
+
+	c, err := r.RowsAffected()
+	if err != nil || c != 1 {
+		return errors.New("Seat not found")
+	}
+	return nil
+}
+
+func readGift(rows *sql.Rows) (*Gift, error) {
+	g := Gift{}
+	err := rows.Scan(
+		&g.ID,
+		&g.PurchaseID,
+		&g.ProductionID,
+		&g.FromEmail,
+		&g.RecipientEmail,
+		&g.Message,
+		&g.DeliverOn,
+		&g.Token,
+		&g.SentOn,
+		&g.Downloaded,
+	)
+	return &g, err
+}
+
+func queryGifts(qry string, args ...interface{}) ([]*Gift, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gifts []*Gift
+	for rows.Next() {
+		g, err := readGift(rows)
+		if err != nil {
+			return nil, err
+		}
+		gifts = append(gifts, g)
+	}
+	return gifts, nil
+}
+
+// GetGift returns the delivered gift matching a download token parts
+func GetGift(email string, productionID int, token string) (*Gift, error) {
+	gifts, err := queryGifts("SELECT * FROM Gifts WHERE RecipientEmail = ? AND ProductionID = ? AND Token = ? AND SentOn IS NOT NULL",
+		email, productionID, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(gifts) == 0 {
+		return nil, errors.New("Gift not found")
+	}
+	return gifts[0], nil
+}
+
+// GetDueGifts returns the gifts not yet sent whose delivery date has come
+func GetDueGifts() ([]*Gift, error) {
+	return queryGifts(`SELECT g.* FROM Gifts g
+    INNER JOIN Purchases p ON p.ID = g.PurchaseID
+  WHERE g.SentOn IS NULL AND g.DeliverOn <= ? AND p.PreorderStatus <> ?
+  ORDER BY g.DeliverOn`, time.Now(), preorderPending)
+}
+
+func insertGift(g *Gift) error {
+	sql, err := db.Prepare(`INSERT INTO Gifts
+    (PurchaseID, ProductionID, FromEmail, RecipientEmail, Message, DeliverOn, Token)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	return sql.QueryRow(g.PurchaseID,
+		g.ProductionID,
+		g.FromEmail,
+		g.RecipientEmail,
+		g.Message,
+		g.DeliverOn,
+		g.Token,
+	).Scan(&g.ID)
+}
+
+func setGiftSent(id int) error {
+	_, err := db.Exec("UPDATE Gifts SET SentOn = ? WHERE ID = ?", time.Now(), id)
+	return err
+}
+
+func increaseGiftDownload(email string, productionID int, token string) error {
+	r, err := db.Exec("UPDATE Gifts SET Downloaded = Downloaded + 1 WHERE RecipientEmail = ? AND ProductionID = ? AND Token = ? AND SentOn IS NOT NULL",
+		email, productionID, token)
+	if err != nil {
+		return err
+	}
+
+	c, err := r.RowsAffected()
+	if err != nil || c != 1 {
+		return errors.New("Gift not found")
+	}
+	return nil
+}
+
+func readSale(rows *sql.Rows) (*Sale, error) {
+	s := Sale{}
+	err := rows.Scan(
+		&s.ID,
+		&s.Name,
+		&s.StartsOn,
+		&s.EndsOn,
+		&s.Percent,
+		&s.Price.Amount,
+	)
+	s.Price.Currency = defaultCurrency
+	return &s, err
+}
+
+func querySales(qry string, args ...interface{}) ([]*Sale, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sales []*Sale
+	for rows.Next() {
+		s, err := readSale(rows)
+		if err != nil {
+			return nil, err
+		}
+		sales = append(sales, s)
+	}
+	return sales, nil
+}
+
+// GetSales returns all sales, latest first, without their productions
+func GetSales() ([]*Sale, error) {
+	return querySales("SELECT * FROM Sales ORDER BY StartsOn DESC")
+}
+
+// GetSale returns a sale with the ids of its productions
+func GetSale(id int) (*Sale, error) {
+	sales, err := querySales("SELECT * FROM Sales WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(sales) == 0 {
+		return nil, fmt.Errorf("sale not found: %d", id)
+	}
+
+	s := sales[0]
+	rows, err := db.Query("SELECT ProductionID FROM SaleProductions WHERE SaleID = ? ORDER BY ProductionID", s.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prodID int
+		if err := rows.Scan(&prodID); err != nil {
+			return nil, err
+		}
+		s.ProductionIDs = append(s.ProductionIDs, prodID)
+	}
+	return s, nil
+}
+
+// getActiveSales returns the sales currently running on a production
+func getActiveSales(productionID int) ([]*Sale, error) {
+	now := time.Now()
+	return querySales(`SELECT s.* FROM Sales s INNER JOIN SaleProductions sp ON sp.SaleID = s.ID
+  WHERE sp.ProductionID = ? AND s.StartsOn <= ? AND s.EndsOn > ?`, productionID, now, now)
+}
+
+func insertSale(s *Sale) (int, error) {
+	sql, err := db.Prepare("INSERT INTO Sales (Name, StartsOn, EndsOn, [Percent], Price) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer sql.Close()
+
+	var id int
+	if err := sql.QueryRow(s.Name, s.StartsOn, s.EndsOn, s.Percent, s.Price.Amount).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, saveSaleProductions(id, s.ProductionIDs)
+}
+
+func updateSale(s *Sale) error {
+	sql, err := db.Prepare(`UPDATE Sales SET
+    Name = ?,
+    StartsOn = ?,
+    EndsOn = ?,
+    [Percent] = ?,
+    Price = ?
+  WHERE ID = ?
+  `)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	_, err = sql.Exec(s.Name, s.StartsOn, s.EndsOn, s.Percent, s.Price.Amount, s.ID)
+	if err != nil {
+		return err
+	}
+	return saveSaleProductions(s.ID, s.ProductionIDs)
+}
+
+func deleteSale(id int) error {
+	_, err := db.Exec("DELETE FROM Sales WHERE ID = ?", id)
+	return err
+}
+
+// saveSaleProductions replaces the productions of a sale
+func saveSaleProductions(saleID int, productionIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM SaleProductions WHERE SaleID = ?", saleID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, id := range productionIDs {
+		if _, err := tx.Exec("INSERT INTO SaleProductions (SaleID, ProductionID) VALUES(?, ?)", saleID, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func readPriceRecord(rows *sql.Rows) (*PriceRecord, error) {
+	p := PriceRecord{}
+	err := rows.Scan(
+		&p.ID,
+		&p.PurchaseID,
+		&p.ProductionID,
+		&p.ListPrice.Currency,
+		&p.ListPrice.Amount,
+		&p.PaidPrice.Amount,
+		&p.SaleID,
+		&p.Reason,
+		&p.RecordedOn,
+	)
+	p.PaidPrice.Currency = p.ListPrice.Currency
+	return &p, err
+}
+
+// GetPriceRecord returns the price paid for a purchase and why
+func GetPriceRecord(purchaseID int) (*PriceRecord, error) {
+	sql, err := db.Prepare("SELECT * FROM PriceHistory WHERE PurchaseID = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return readPriceRecord(rows)
+	}
+	return nil, fmt.Errorf("no price history for purchase: %d", purchaseID)
+}
+
+func insertPriceRecord(p *PriceRecord) error {
+	sql, err := db.Prepare(`INSERT INTO PriceHistory
+    (PurchaseID, ProductionID, Currency, ListPrice, PaidPrice, SaleID, Reason, RecordedOn)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	p.RecordedOn = time.Now()
+	return sql.QueryRow(p.PurchaseID,
+		p.ProductionID,
+		p.ListPrice.Currency,
+		p.ListPrice.Amount,
+		p.PaidPrice.Amount,
+		p.SaleID,
+		p.Reason,
+		p.RecordedOn,
+	).Scan(&p.ID)
+}
+
+// GetPurchases returns all purchases made by an email, latest first
+func GetPurchases(email string) ([]*Purchase, error) {
+	sql, err := db.Prepare("SELECT * FROM Purchases WHERE Email = ? ORDER BY PurchasedDate DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purchases []*Purchase
+	for rows.Next() {
+		p, err := readPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	return purchases, nil
+}
+
+func readAffiliate(rows *sql.Rows) (*Affiliate, error) {
+	a := Affiliate{}
+	err := rows.Scan(
+		&a.ID,
+		&a.Code,
+		&a.Name,
+		&a.Email,
+		&a.CommissionPercent,
+		&a.IsActive,
+		&a.CreatedOn,
+	)
+	return &a, err
+}
+
+func queryAffiliates(qry string, args ...interface{}) ([]*Affiliate, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var affiliates []*Affiliate
+	for rows.Next() {
+		a, err := readAffiliate(rows)
+		if err != nil {
+			return nil, err
+		}
+		affiliates = append(affiliates, a)
+	}
+	return affiliates, nil
+}
+
+// GetAffiliates returns all affiliates, inactive ones included
+func GetAffiliates() ([]*Affiliate, error) {
+	return queryAffiliates("SELECT * FROM Affiliates ORDER BY Code")
+}
+
+// GetAffiliate returns an affiliate by id or referral code
+func GetAffiliate(id int, code string) (*Affiliate, error) {
+	var affiliates []*Affiliate
+	var err error
+	if id > 0 {
+		affiliates, err = queryAffiliates("SELECT * FROM Affiliates WHERE ID = ?", id)
+	} else {
+		affiliates, err = queryAffiliates("SELECT * FROM Affiliates WHERE Code = ?", code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(affiliates) == 0 {
+		return nil, fmt.Errorf("affiliate not found: %d %s", id, code)
+	}
+	return affiliates[0], nil
+}
+
+func insertAffiliate(a *Affiliate) (int, error) {
+	sql, err := db.Prepare("INSERT INTO Affiliates (Code, Name, Email, CommissionPercent, IsActive, CreatedOn) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer sql.Close()
+
+	var id int
+	err = sql.QueryRow(a.Code, a.Name, a.Email, a.CommissionPercent, a.IsActive, time.Now()).Scan(&id)
+	return id, err
+}
+
+func updateAffiliate(a *Affiliate) error {
+	sql, err := db.Prepare(`UPDATE Affiliates SET
+    Code = ?,
+    Name = ?,
+    Email = ?,
+    CommissionPercent = ?,
+    IsActive = ?
+  WHERE ID = ?
+  `)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	_, err = sql.Exec(a.Code, a.Name, a.Email, a.CommissionPercent, a.IsActive, a.ID)
+	return err
+}
+
+// getCommissionEntries returns the purchases made through an affiliate
+// between from and to, and the refunds of those purchases made in the period
+func getCommissionEntries(from, to time.Time) ([]*commissionEntry, error) {
+	sql, err := db.Prepare(`SELECT a.Code, a.Name, a.Email, p.Currency, p.Subtotal, p.Commission, p.Amount, 0
+  FROM Purchases p INNER JOIN Affiliates a ON a.ID = p.AffiliateID
+  WHERE p.PurchasedDate >= ? AND p.PurchasedDate < ?
+  UNION ALL
+  SELECT a.Code, a.Name, a.Email, p.Currency, p.Subtotal, p.Commission, p.Amount, rf.Amount
+  FROM Refunds rf
+    INNER JOIN Purchases p ON p.ID = rf.PurchaseID
+    INNER JOIN Affiliates a ON a.ID = p.AffiliateID
+  WHERE rf.RefundedOn >= ? AND rf.RefundedOn < ? AND p.Amount > 0
+  ORDER BY 1, 4`)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(from, to, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*commissionEntry
+	for rows.Next() {
+		e := commissionEntry{}
+		err := rows.Scan(&e.Code, &e.Name, &e.Email, &e.Currency, &e.Subtotal, &e.Commission, &e.Amount, &e.Refunded)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+func readInstructor(rows *sql.Rows) (*Instructor, error) {
+	i := Instructor{}
+	err := rows.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.SharePercent,
+		&i.CreatedOn,
+	)
+	return &i, err
+}
+
+func queryInstructors(qry string, args ...interface{}) ([]*Instructor, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instructors []*Instructor
+	for rows.Next() {
+		i, err := readInstructor(rows)
+		if err != nil {
+			return nil, err
+		}
+		instructors = append(instructors, i)
+	}
+	return instructors, nil
+}
+
+// GetInstructors returns all instructors without their productions
+func GetInstructors() ([]*Instructor, error) {
+	return queryInstructors("SELECT * FROM Instructors ORDER BY Name")
+}
+
+// GetInstructor returns an instructor with the ids of their productions
+func GetInstructor(id int) (*Instructor, error) {
+	instructors, err := queryInstructors("SELECT * FROM Instructors WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(instructors) == 0 {
+		return nil, fmt.Errorf("instructor not found: %d", id)
+	}
+
+	i := instructors[0]
+	rows, err := db.Query("SELECT ProductionID FROM ProductionInstructors WHERE InstructorID = ? ORDER BY ProductionID", i.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prodID int
+		if err := rows.Scan(&prodID); err != nil {
+			return nil, err
+		}
+		i.ProductionIDs = append(i.ProductionIDs, prodID)
+	}
+	return i, nil
+}
+
+// getProductionInstructors returns the instructors paid on a production
+func getProductionInstructors(productionID int) ([]*Instructor, error) {
+	return queryInstructors(`SELECT i.* FROM Instructors i INNER JOIN ProductionInstructors pi ON pi.InstructorID = i.ID
+  WHERE pi.ProductionID = ?`, productionID)
+}
+
+func insertInstructor(i *Instructor) (int, error) {
+	sql, err := db.Prepare("INSERT INTO Instructors (Name, Email, SharePercent, CreatedOn) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer sql.Close()
+
+	var id int
+	if err := sql.QueryRow(i.Name, i.Email, i.SharePercent, time.Now()).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, saveInstructorProductions(id, i.ProductionIDs)
+}
+
+func updateInstructor(i *Instructor) error {
+	sql, err := db.Prepare(`UPDATE Instructors SET
+    Name = ?,
+    Email = ?,
+    SharePercent = ?
+  WHERE ID = ?
+  `)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	_, err = sql.Exec(i.Name, i.Email, i.SharePercent, i.ID)
+	if err != nil {
+		return err
+	}
+	return saveInstructorProductions(i.ID, i.ProductionIDs)
+}
+
+// saveInstructorProductions replaces the productions of an instructor
+func saveInstructorProductions(instructorID int, productionIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM ProductionInstructors WHERE InstructorID = ?", instructorID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, id := range productionIDs {
+		if _, err := tx.Exec("INSERT INTO ProductionInstructors (ProductionID, InstructorID) VALUES(?, ?)", id, instructorID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// saveRoyalty inserts or recalculates the royalty of an instructor on a purchase
+func saveRoyalty(r *Royalty) error {
+	r.CalculatedOn = time.Now()
+	_, err := db.Exec(`MERGE Royalties AS r
+  USING (SELECT ? AS PurchaseID, ? AS InstructorID) AS src
+  ON r.PurchaseID = src.PurchaseID AND r.InstructorID = src.InstructorID
+  WHEN MATCHED THEN UPDATE SET Refunded = ?, Fees = ?, Net = ?, SharePercent = ?, Amount = ?, CalculatedOn = ?
+  WHEN NOT MATCHED THEN INSERT (PurchaseID, InstructorID, Currency, Refunded, Fees, Net, SharePercent, Amount, CalculatedOn)
+    VALUES (src.PurchaseID, src.InstructorID, ?, ?, ?, ?, ?, ?, ?);`,
+		r.PurchaseID, r.InstructorID,
+		r.Refunded.Amount, r.Fees.Amount, r.Net.Amount, r.SharePercent, r.Amount.Amount, r.CalculatedOn,
+		r.Amount.Currency, r.Refunded.Amount, r.Fees.Amount, r.Net.Amount, r.SharePercent, r.Amount.Amount, r.CalculatedOn)
+	return err
+}
+
+// getRoyaltySharePercent returns the share used when the royalty of a
+// purchase was first calculated, so later changes to the instructor's share
+// do not alter past sales
+func getRoyaltySharePercent(purchaseID, instructorID int) (int, bool) {
+	var share int
+	err := db.QueryRow("SELECT SharePercent FROM Royalties WHERE PurchaseID = ? AND InstructorID = ?", purchaseID, instructorID).Scan(&share)
+	return share, err == nil
+}
+
+// GetChargePurchases returns the purchases paid by a charge
+func GetChargePurchases(chargeID string) ([]*Purchase, error) {
+	sql, err := db.Prepare("SELECT * FROM Purchases WHERE ChargeID = ? ORDER BY ID")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(chargeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purchases []*Purchase
+	for rows.Next() {
+		p, err := readPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	return purchases, nil
+}
+
+// insertRefund saves a refund and the total refunded on its purchase
+func insertRefund(rf *Refund, refunded int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow("INSERT INTO Refunds (PurchaseID, Amount, Currency, RefundedOn) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?)",
+		rf.PurchaseID, rf.Amount.Amount, rf.Amount.Currency, rf.RefundedOn).Scan(&rf.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE Purchases SET Refunded = ? WHERE ID = ?", refunded, rf.PurchaseID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// getRoyaltyStatement returns the royalties of an instructor on the purchases
+// made between from and to, followed by the refunds made in that period which
+// take the refunded part of the subtotal off the net
+func getRoyaltyStatement(instructorID int, from, to time.Time) ([]*royaltyLine, error) {
+	sql, err := db.Prepare(`SELECT p.PurchasedDate, pr.Title, p.Subtotal, r.Currency, r.Refunded, r.Fees, r.Net, r.SharePercent, r.Amount
+  FROM Royalties r
+    INNER JOIN Purchases p ON p.ID = r.PurchaseID
+    INNER JOIN Productions pr ON pr.ID = p.ProductionID
+  WHERE r.InstructorID = ? AND p.PurchasedDate >= ? AND p.PurchasedDate < ?
+  UNION ALL
+  SELECT rf.RefundedOn, pr.Title, 0, r.Currency, rf.Amount * p.Subtotal / p.Amount, 0,
+    -(rf.Amount * p.Subtotal / p.Amount), r.SharePercent, -(rf.Amount * p.Subtotal / p.Amount * r.SharePercent / 100)
+  FROM Refunds rf
+    INNER JOIN Purchases p ON p.ID = rf.PurchaseID
+    INNER JOIN Royalties r ON r.PurchaseID = p.ID
+    INNER JOIN Productions pr ON pr.ID = p.ProductionID
+  WHERE r.InstructorID = ? AND rf.RefundedOn >= ? AND rf.RefundedOn < ? AND p.Amount > 0
+  ORDER BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(instructorID, from, to, instructorID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []*royaltyLine
+	for rows.Next() {
+		l := royaltyLine{}
+		var currency string
+		err := rows.Scan(&l.Date, &l.Title, &l.Subtotal.Amount, &currency, &l.Refunded.Amount, &l.Fees.Amount, &l.Net.Amount, &l.SharePercent, &l.Royalty.Amount)
+		if err != nil {
+			return nil, err
+		}
+		l.Subtotal.Currency = currency
+		l.Refunded.Currency = currency
+		l.Fees.Currency = currency
+		l.Net.Currency = currency
+		l.Royalty.Currency = currency
+		lines = append(lines, &l)
+	}
+	return lines, nil
+}
+
+// GetPendingPreorders returns the purchases of a production made before its
+// release whose download email has not been sent
+func GetPendingPreorders(productionID int) ([]*Purchase, error) {
+	sql, err := db.Prepare("SELECT * FROM Purchases WHERE ProductionID = ? AND PreorderStatus = ? ORDER BY ID")
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(productionID, preorderPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var purchases []*Purchase
+	for rows.Next() {
+		p, err := readPurchase(rows)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+	}
+	return purchases, nil
+}
+
+// getPreorderedProductions returns the ids of the productions having pre-orders
+// waiting for their release
+func getPreorderedProductions() ([]int, error) {
+	rows, err := db.Query("SELECT DISTINCT ProductionID FROM Purchases WHERE PreorderStatus = ?", preorderPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func setPreorderStatus(purchaseID int, status string) error {
+	_, err := db.Exec("UPDATE Purchases SET PreorderStatus = ? WHERE ID = ?", status, purchaseID)
+	return err
+}
+
+// getSalesEntries returns the purchases and the refunds made between from and
+// to for the accounting export, with the promotion applied and the fees of the
+// purchases, estimated from the total of their charge when not recorded
+func getSalesEntries(from, to time.Time) ([]*salesEntry, error) {
+	sql, err := db.Prepare(`SELECT p.PurchasedDate, p.OrderID, p.ChargeID, p.Email, p.ProductionID, pr.Title, p.Currency,
+    COALESCE(h.ListPrice, p.Subtotal), COALESCE(s.Name, h.Reason, ''), p.Subtotal,
+    p.GST, p.HST, p.PST, p.QST, p.Amount, 0, p.PaymentFees,
+    SUM(p.Amount) OVER (PARTITION BY p.ChargeID), 0, p.ID
+  FROM Purchases p
+    INNER JOIN Productions pr ON pr.ID = p.ProductionID
+    LEFT JOIN PriceHistory h ON h.PurchaseID = p.ID
+    LEFT JOIN Sales s ON s.ID = h.SaleID
+  WHERE p.PurchasedDate >= ? AND p.PurchasedDate < ?
+  UNION ALL
+  SELECT rf.RefundedOn, p.OrderID, p.ChargeID, p.Email, p.ProductionID, pr.Title, p.Currency,
+    0, '', 0, p.GST, p.HST, p.PST, p.QST, p.Amount, rf.Amount, 0, 0, 1, rf.ID
+  FROM Refunds rf
+    INNER JOIN Purchases p ON p.ID = rf.PurchaseID
+    INNER JOIN Productions pr ON pr.ID = p.ProductionID
+  WHERE rf.RefundedOn >= ? AND rf.RefundedOn < ?
+  ORDER BY 1, 19, 20`)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(from, to, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*salesEntry
+	for rows.Next() {
+		e := salesEntry{}
+		var fees *int
+		var chargeTotal, id int
+		err := rows.Scan(&e.Date, &e.OrderID, &e.ChargeID, &e.Email, &e.ProductionID, &e.Title, &e.Currency,
+			&e.ListPrice, &e.Promotion, &e.Subtotal,
+			&e.Taxes.GST, &e.Taxes.HST, &e.Taxes.PST, &e.Taxes.QST, &e.Amount, &e.Refunded, &fees,
+			&chargeTotal, &e.Refund, &id)
+		if err != nil {
+			return nil, err
+		}
+		if e.Refund {
+			// a refund reads the taxes and amount of its purchase to split
+			// the amount refunded
+			e.RefundedTaxes = refundedTaxes(e.Taxes, e.Amount, e.Refunded)
+			e.Taxes = Taxes{}
+			e.Amount = 0
+		} else if fees != nil {
+			e.Fees = *fees
+		} else {
+			e.Fees = paymentFees(Money{Amount: e.Amount, Currency: e.Currency}, Money{Amount: chargeTotal, Currency: e.Currency}).Amount
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+func readOutboxEmail(rows *sql.Rows) (*OutboxEmail, error) {
+	e := OutboxEmail{}
+	err := rows.Scan(
+		&e.ID,
+		&e.From,
+		&e.To,
+		&e.ReplyTo,
+		&e.Subject,
+		&e.HTML,
+		&e.Text,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptOn,
+		&e.LastError,
+		&e.CreatedOn,
+		&e.SentOn,
+		&e.Headers,
+		&e.MessageID,
+	)
+	return &e, err
+}
+
+func queryOutboxEmails(qry string, args ...interface{}) ([]*OutboxEmail, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []*OutboxEmail
+	for rows.Next() {
+		e, err := readOutboxEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	return emails, nil
+}
+
+// GetOutboxEmails returns the latest emails of the outbox with a status
+func GetOutboxEmails(status string) ([]*OutboxEmail, error) {
+	return queryOutboxEmails("SELECT TOP 200 * FROM EmailOutbox WHERE Status = ? ORDER BY ID DESC", status)
+}
+
+func GetOutboxEmail(id int) (*OutboxEmail, error) {
+	emails, err := queryOutboxEmails("SELECT * FROM EmailOutbox WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, errors.New("Email not found")
+	}
+	return emails[0], nil
+}
+
+// GetDueOutboxEmails returns the pending emails whose next attempt has come
+func GetDueOutboxEmails() ([]*OutboxEmail, error) {
+	return queryOutboxEmails("SELECT TOP 50 * FROM EmailOutbox WHERE Status = ? AND NextAttemptOn <= ? ORDER BY NextAttemptOn", outboxPending, time.Now())
+}
+
+func insertOutboxEmail(e *OutboxEmail, attachments []attachment) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`INSERT INTO EmailOutbox
+    (FromAddress, ToAddress, ReplyTo, Subject, HTML, Text, Status, Attempts, NextAttemptOn, LastError, CreatedOn, Headers, MessageID)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.From,
+		e.To,
+		e.ReplyTo,
+		e.Subject,
+		e.HTML,
+		e.Text,
+		e.Status,
+		e.Attempts,
+		e.NextAttemptOn,
+		e.LastError,
+		e.CreatedOn,
+		e.Headers,
+		e.MessageID,
+	).Scan(&e.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, a := range attachments {
+		if _, err := tx.Exec("INSERT INTO EmailAttachments (EmailID, Filename, Data) VALUES(?, ?, ?)", e.ID, a.Filename, a.Data); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func getEmailAttachments(emailID int) ([]attachment, error) {
+	rows, err := db.Query("SELECT Filename, Data FROM EmailAttachments WHERE EmailID = ? ORDER BY ID", emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []attachment
+	for rows.Next() {
+		a := attachment{}
+		if err := rows.Scan(&a.Filename, &a.Data); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+func setOutboxSent(id int) error {
+	_, err := db.Exec("UPDATE EmailOutbox SET Status = ?, Attempts = Attempts + 1, LastError = '', SentOn = ? WHERE ID = ?",
+		outboxSent, time.Now(), id)
+	return err
+}
+
+func setOutboxFailed(e *OutboxEmail) error {
+	_, err := db.Exec("UPDATE EmailOutbox SET Status = ?, Attempts = ?, NextAttemptOn = ?, LastError = ? WHERE ID = ?",
+		e.Status, e.Attempts, e.NextAttemptOn, e.LastError, e.ID)
+	return err
+}
+
+// retryOutboxEmail queues an unsent email again with all its attempts
+func retryOutboxEmail(id int) error {
+	r, err := db.Exec("UPDATE EmailOutbox SET Status = ?, Attempts = 0, NextAttemptOn = ? WHERE ID = ? AND Status <> ?",
+		outboxPending, time.Now(), id, outboxSent)
+	if err != nil {
+		return err
+	}
+
+	c, err := r.RowsAffected()
+	if err != nil || c != 1 {
+		return errors.New("Unsent email not found")
+	}
+	return nil
+}
+
+func readSubscriber(rows *sql.Rows) (*Subscriber, error) {
+	sub := Subscriber{}
+	err := rows.Scan(
+		&sub.ID,
+		&sub.Email,
+		&sub.Status,
+		&sub.Source,
+		&sub.CreatedOn,
+		&sub.ConfirmedOn,
+		&sub.UnsubscribedOn,
+	)
+	return &sub, err
+}
+
+func querySubscribers(qry string, args ...interface{}) ([]*Subscriber, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []*Subscriber
+	for rows.Next() {
+		sub, err := readSubscriber(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, sub)
+	}
+	return subscribers, nil
+}
+
+func GetSubscriber(email string) (*Subscriber, error) {
+	subscribers, err := querySubscribers("SELECT * FROM Subscribers WHERE Email = ?", email)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscribers) == 0 {
+		return nil, errors.New("Subscriber not found")
+	}
+	return subscribers[0], nil
+}
+
+// GetConfirmedSubscribers returns the subscribers receiving our campaigns
+func GetConfirmedSubscribers() ([]*Subscriber, error) {
+	return querySubscribers("SELECT * FROM Subscribers WHERE Status = ? ORDER BY ID", subscriberConfirmed)
+}
+
+func insertSubscriber(sub *Subscriber) error {
+	sql, err := db.Prepare(`INSERT INTO Subscribers
+    (Email, Status, Source, CreatedOn)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	sub.CreatedOn = time.Now()
+	return sql.QueryRow(sub.Email, sub.Status, sub.Source, sub.CreatedOn).Scan(&sub.ID)
+}
+
+// setSubscriberStatus changes the status of a subscriber and records when it
+// was confirmed or unsubscribed
+func setSubscriberStatus(email, status string) error {
+	var qry string
+	switch status {
+	case subscriberConfirmed:
+		qry = "UPDATE Subscribers SET Status = ?, ConfirmedOn = ?, UnsubscribedOn = NULL WHERE Email = ?"
+	case subscriberUnsubscribed:
+		qry = "UPDATE Subscribers SET Status = ?, UnsubscribedOn = ? WHERE Email = ?"
+	default:
+		qry = "UPDATE Subscribers SET Status = ?, CreatedOn = ? WHERE Email = ?"
+	}
+
+	r, err := db.Exec(qry, status, time.Now(), email)
+	if err != nil {
+		return err
+	}
+
+	c, err := r.RowsAffected()
+	if err != nil || c != 1 {
+		return errors.New("Subscriber not found")
+	}
+	return nil
+}
+
+// getEpisodeRecipients returns the customers who can download a production,
+// with the reference of their download link: buyers, team license seats and
+// delivered gifts. Pending pre-orders, refunded purchases and customers who
+// opted out are left out
+func getEpisodeRecipients(productionID int) ([]*episodeRecipient, error) {
+	rows, err := db.Query(`SELECT Email, Ref FROM (
+    SELECT Email, ChargeID AS Ref, PurchasedDate AS Since FROM Purchases
+      WHERE ProductionID = ? AND Seats = 1 AND IsGift = 0 AND PreorderStatus <> ? AND (Amount = 0 OR Refunded < Amount)
+    UNION ALL
+    SELECT s.Email, s.Token, p.PurchasedDate FROM LicenseSeats s INNER JOIN Purchases p ON p.ID = s.PurchaseID
+      WHERE p.ProductionID = ? AND s.Email <> ''
+    UNION ALL
+    SELECT RecipientEmail, Token, SentOn FROM Gifts
+      WHERE ProductionID = ? AND SentOn IS NOT NULL
+  ) r
+  WHERE Email NOT IN (SELECT Email FROM EpisodeNotificationOptOuts)
+  ORDER BY Since DESC`, productionID, preorderPending, productionID, productionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []*episodeRecipient
+	for rows.Next() {
+		r := episodeRecipient{}
+		if err := rows.Scan(&r.Email, &r.Ref); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, &r)
+	}
+	return recipients, nil
+}
+
+// optOutEpisodeNotifications stops the new episode emails of a customer
+func optOutEpisodeNotifications(email string) error {
+	_, err := db.Exec(`IF NOT EXISTS (SELECT 1 FROM EpisodeNotificationOptOuts WHERE Email = ?)
+  INSERT INTO EpisodeNotificationOptOuts (Email, OptedOutOn) VALUES(?, ?)`, email, email, time.Now())
+	return err
+}
+
+func readDripCourse(rows *sql.Rows) (*DripCourse, error) {
+	c := DripCourse{}
+	err := rows.Scan(
+		&c.ID,
+		&c.Slug,
+		&c.Title,
+		&c.Description,
+		&c.ProductionID,
+		&c.IsActive,
+	)
+	return &c, err
+}
+
+func queryDripCourses(qry string, args ...interface{}) ([]*DripCourse, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []*DripCourse
+	for rows.Next() {
+		c, err := readDripCourse(rows)
+		if err != nil {
+			return nil, err
+		}
+		courses = append(courses, c)
+	}
+	return courses, nil
+}
+
+// GetDripCourses returns all drip courses, inactive ones included
+func GetDripCourses() ([]*DripCourse, error) {
+	return queryDripCourses("SELECT * FROM DripCourses ORDER BY Title")
+}
+
+// GetDripCourse returns a drip course by id or slug with its steps
+func GetDripCourse(id int, slug string) (*DripCourse, error) {
+	var courses []*DripCourse
+	var err error
+	if id > 0 {
+		courses, err = queryDripCourses("SELECT * FROM DripCourses WHERE ID = ?", id)
+	} else {
+		courses, err = queryDripCourses("SELECT * FROM DripCourses WHERE Slug = ?", slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(courses) == 0 {
+		return nil, errors.New("Drip course not found")
+	}
+
+	c := courses[0]
+	c.Steps, err = getDripSteps(c.ID)
+	return c, err
+}
+
+func getDripSteps(courseID int) ([]*DripStep, error) {
+	rows, err := db.Query("SELECT Position, DelayDays, Template FROM DripSteps WHERE CourseID = ? ORDER BY Position", courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []*DripStep
+	for rows.Next() {
+		s := DripStep{}
+		if err := rows.Scan(&s.Position, &s.DelayDays, &s.Template); err != nil {
+			return nil, err
+		}
+		steps = append(steps, &s)
+	}
+	return steps, nil
+}
+
+func insertDripCourse(c *DripCourse) (int, error) {
+	sql, err := db.Prepare("INSERT INTO DripCourses (Slug, Title, Description, ProductionID, IsActive) OUTPUT INSERTED.ID VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer sql.Close()
+
+	var id int
+	if err := sql.QueryRow(c.Slug, c.Title, c.Description, c.ProductionID, c.IsActive).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, saveDripSteps(id, c.Steps)
+}
+
+func updateDripCourse(c *DripCourse) error {
+	sql, err := db.Prepare(`UPDATE DripCourses SET
+    Slug = ?,
+    Title = ?,
+    Description = ?,
+    ProductionID = ?,
+    IsActive = ?
+  WHERE ID = ?
+  `)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	_, err = sql.Exec(c.Slug, c.Title, c.Description, c.ProductionID, c.IsActive, c.ID)
+	if err != nil {
+		return err
+	}
+	return saveDripSteps(c.ID, c.Steps)
+}
+
+func deleteDripCourse(id int) error {
+	_, err := db.Exec("DELETE FROM DripCourses WHERE ID = ?", id)
+	return err
+}
+
+// saveDripSteps replaces the steps of a drip course, numbered in order
+func saveDripSteps(courseID int, steps []*DripStep) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM DripSteps WHERE CourseID = ?", courseID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, s := range steps {
+		s.Position = i
+		if _, err := tx.Exec("INSERT INTO DripSteps (CourseID, Position, DelayDays, Template) VALUES(?, ?, ?, ?)",
+			courseID, s.Position, s.DelayDays, s.Template); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func readDripEnrollment(rows *sql.Rows) (*DripEnrollment, error) {
+	e := DripEnrollment{}
+	err := rows.Scan(
+		&e.ID,
+		&e.CourseID,
+		&e.Email,
+		&e.EnrolledOn,
+		&e.NextStep,
+		&e.Status,
+		&e.StoppedOn,
+	)
+	return &e, err
+}
+
+func queryDripEnrollments(qry string, args ...interface{}) ([]*DripEnrollment, error) {
+	sql, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer sql.Close()
+
+	rows, err := sql.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var enrollments []*DripEnrollment
+	for rows.Next() {
+		e, err := readDripEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, nil
+}
+
+func GetDripEnrollment(id int) (*DripEnrollment, error) {
+	enrollments, err := queryDripEnrollments("SELECT * FROM DripEnrollments WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(enrollments) == 0 {
+		return nil, errors.New("Enrollment not found")
+	}
+	return enrollments[0], nil
+}
+
+// GetDueDripEnrollments returns the active enrollments whose next step is due
+func GetDueDripEnrollments() ([]*DripEnrollment, error) {
+	return queryDripEnrollments(`SELECT e.* FROM DripEnrollments e
+    INNER JOIN DripSteps s ON s.CourseID = e.CourseID AND s.Position = e.NextStep
+  WHERE e.Status = ? AND DATEADD(day, s.DelayDays, e.EnrolledOn) <= ?
+  ORDER BY e.EnrolledOn`, dripActive, time.Now())
+}
+
+// enrollDrip enrolls an email in a course pending its confirmation, a stopped
+// or unconfirmed enrollment starts over
+func enrollDrip(courseID int, email string) (*DripEnrollment, error) {
+	e := &DripEnrollment{CourseID: courseID, Email: email, EnrolledOn: time.Now(), Status: dripPending}
+	err := db.QueryRow(`MERGE DripEnrollments AS t
+  USING (SELECT ? AS CourseID, ? AS Email) AS s
+  ON t.CourseID = s.CourseID AND t.Email = s.Email
+  WHEN MATCHED AND t.Status <> ? THEN
+    UPDATE SET EnrolledOn = ?, NextStep = 0, Status = ?, StoppedOn = NULL
+  WHEN NOT MATCHED THEN
+    INSERT (CourseID, Email, EnrolledOn, NextStep, Status) VALUES(s.CourseID, s.Email, ?, 0, ?)
+  OUTPUT INSERTED.ID;`,
+		courseID, email, dripActive, e.EnrolledOn, dripPending, e.EnrolledOn, dripPending).Scan(&e.ID)
+	if err == sql.ErrNoRows {
+		// already following the course
+		return nil, nil
+	}
+	return e, err
+}
+
+// confirmDripEnrollment starts a pending enrollment, its delays count from
+// now. It returns false when the enrollment was already confirmed
+func confirmDripEnrollment(id int) (bool, error) {
+	res, err := db.Exec("UPDATE DripEnrollments SET Status = ?, EnrolledOn = ? WHERE ID = ? AND Status = ?", dripActive, time.Now(), id, dripPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// claimDripStep moves an active enrollment past a step, it returns false when
+// the step was already claimed so it is only sent once
+func claimDripStep(id, step int) (bool, error) {
+	res, err := db.Exec("UPDATE DripEnrollments SET NextStep = ? WHERE ID = ? AND NextStep = ? AND Status = ?", step+1, id, step, dripActive)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// stopDripEnrollment stops an active enrollment with a final status
+func stopDripEnrollment(id int, status string) error {
+	_, err := db.Exec("UPDATE DripEnrollments SET Status = ?, StoppedOn = ? WHERE ID = ? AND Status = ?", status, time.Now(), id, dripActive)
+	return err
+}
+
+// stopDripEnrollmentsForPurchase stops the courses offering a production the
+// email just bought
+func stopDripEnrollmentsForPurchase(email string, productionID int) error {
+	_, err := db.Exec(`UPDATE DripEnrollments SET Status = ?, StoppedOn = ?
+  WHERE Email = ? AND Status = ? AND CourseID IN (SELECT ID FROM DripCourses WHERE ProductionID = ?)`,
+		dripPurchased, time.Now(), email, dripActive, productionID)
+	return err
+}
+
+// GetSuppressions returns the suppressed addresses, most recent first
+func GetSuppressions() ([]*Suppression, error) {
+	rows, err := db.Query("SELECT * FROM EmailSuppressions ORDER BY SuppressedOn DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suppressions []*Suppression
+	for rows.Next() {
+		s := Suppression{}
+		if err := rows.Scan(&s.Email, &s.Reason, &s.Detail, &s.SuppressedOn); err != nil {
+			return nil, err
+		}
+		suppressions = append(suppressions, &s)
+	}
+	return suppressions, nil
+}
+
+func isSuppressed(email string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM EmailSuppressions WHERE Email = ?", email).Scan(&n)
+	return n > 0, err
+}
+
+// insertSuppression suppresses an address, an existing suppression is kept
+func insertSuppression(s *Suppression) error {
+	_, err := db.Exec(`IF NOT EXISTS (SELECT 1 FROM EmailSuppressions WHERE Email = ?)
+  INSERT INTO EmailSuppressions (Email, Reason, Detail, SuppressedOn) VALUES(?, ?, ?, ?)`,
+		s.Email, s.Email, s.Reason, s.Detail, s.SuppressedOn)
+	return err
+}
+
+func deleteSuppression(email string) error {
+	_, err := db.Exec("DELETE FROM EmailSuppressions WHERE Email = ?", email)
+	return err
+}
+
+// flagUndeliveredPurchases marks the purchases confirmed by an email that
+// bounced, the customer never received their download links
+func flagUndeliveredPurchases(messageID string) error {
+	_, err := db.Exec("UPDATE Purchases SET EmailUndelivered = 1 WHERE ConfirmationMessageID = ?", messageID)
+	return err
+}
+
+// clearUndeliveredPurchases removes the flag from the purchases of an address
+// once support fixed its delivery
+func clearUndeliveredPurchases(email string) error {
+	_, err := db.Exec("UPDATE Purchases SET EmailUndelivered = 0 WHERE Email = ?", email)
+	return err
+}
+
+// GetContactMessages returns the latest messages of the contact form
+func GetContactMessages() ([]*ContactMessage, error) {
+	rows, err := db.Query("SELECT TOP 100 * FROM ContactMessages ORDER BY CreatedOn DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*ContactMessage
+	for rows.Next() {
+		m := ContactMessage{}
+		if err := rows.Scan(&m.ID, &m.Name, &m.Email, &m.Subject, &m.Message, &m.IP, &m.CreatedOn); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &m)
+	}
+	return messages, nil
+}
+
+func insertContactMessage(m *ContactMessage) error {
+	sql, err := db.Prepare(`INSERT INTO ContactMessages
+    (Name, Email, Subject, Message, IP, CreatedOn)
+  OUTPUT INSERTED.ID
+  VALUES(?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer sql.Close()
+
+	m.CreatedOn = time.Now()
+	return sql.QueryRow(m.Name, m.Email, m.Subject, m.Message, m.IP, m.CreatedOn).Scan(&m.ID)
+}
+
+// countRateLimitHits returns how many requests of an action a client made
+// since a time
+func countRateLimitHits(action, client string, since time.Time) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM RateLimitHits WHERE Action = ? AND Client = ? AND CreatedOn >= ?", action, client, since).Scan(&n)
+	return n, err
+}
+
+func insertRateLimitHit(action, client string) error {
+	_, err := db.Exec("INSERT INTO RateLimitHits (Action, Client, CreatedOn) VALUES(?, ?, ?)", action, client, time.Now())
+	return err
+}
+
+// deleteRateLimitHits removes the requests made before a time
+func deleteRateLimitHits(before time.Time) error {
+	_, err := db.Exec("DELETE FROM RateLimitHits WHERE CreatedOn < ?", before)
+	return err
+}
+
+// GetCustomer returns the account of an email
+func GetCustomer(email string) (*Customer, error) {
+	c := Customer{}
+	err := db.QueryRow("SELECT * FROM Customers WHERE Email = ?", email).Scan(&c.ID, &c.Email, &c.CreatedOn, &c.LastLoginOn)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("Customer not found")
+	}
+	return &c, err
+}
+
+// recordLogin creates the account of an email on its first sign in and
+// records the last one
+func recordLogin(email string) error {
+	now := time.Now()
+	_, err := db.Exec(`IF EXISTS (SELECT 1 FROM Customers WHERE Email = ?)
+    UPDATE Customers SET LastLoginOn = ? WHERE Email = ?
+  ELSE
+    INSERT INTO Customers (Email, CreatedOn, LastLoginOn) VALUES(?, ?, ?)`,
+		email, now, email, email, now, now)
+	return err
+}
+
+func insertLoginToken(tokenHash, email string, expiresOn time.Time) error {
+	_, err := db.Exec("INSERT INTO LoginTokens (TokenHash, Email, CreatedOn, ExpiresOn) VALUES(?, ?, ?, ?)",
+		tokenHash, email, time.Now(), expiresOn)
+	return err
+}
+
+// useLoginToken marks a sign in token as used and returns its email, a token
+// works once and before it expires
+func useLoginToken(tokenHash string) (string, error) {
+	now := time.Now()
+	var email string
+	err := db.QueryRow(`UPDATE LoginTokens SET UsedOn = ?
+  OUTPUT INSERTED.Email
+  WHERE TokenHash = ? AND UsedOn IS NULL AND ExpiresOn > ?`, now, tokenHash, now).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", errors.New("Login token not found")
+	}
+	return email, err
+}
+
+// getEpisodesSince returns the episodes of a production released after a date
+func getEpisodesSince(productionID int, since time.Time) ([]*Episode, error) {
+	rows, err := db.Query("SELECT * FROM Episodes WHERE ProductionID = ? AND ReleasedOn > ? ORDER BY ReleasedOn", productionID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []*Episode
+	for rows.Next() {
+		e, err := readEpisode(rows)
+		if err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, nil
+}
+
+// getSessionEmail returns the email signed in a session still valid
+func getSessionEmail(id string) (string, error) {
+	var email string
+	err := db.QueryRow("SELECT Email FROM Sessions WHERE ID = ? AND ExpiresOn > ?", id, time.Now()).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", errors.New("Session not found")
+	}
+	return email, err
+}
+
+func insertSession(id, email string, expiresOn time.Time) error {
+	_, err := db.Exec("INSERT INTO Sessions (ID, Email, CreatedOn, ExpiresOn) VALUES(?, ?, ?, ?)", id, email, time.Now(), expiresOn)
+	return err
+}
+
+func deleteSession(id string) error {
+	_, err := db.Exec("DELETE FROM Sessions WHERE ID = ?", id)
+	return err
+}
+
+// deleteExpiredSessions removes the sessions past their expiry
+func deleteExpiredSessions() error {
+	_, err := db.Exec("DELETE FROM Sessions WHERE ExpiresOn <= ?", time.Now())
+	return err
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statuses of a drip course enrollment, it is pending until the email is
+// confirmed and stops on the last step, on a purchase of the offered
+// production or on unsubscribe
+const (
+	dripPending      = "pending"
+	dripActive       = "active"
+	dripCompleted    = "completed"
+	dripPurchased    = "purchased"
+	dripUnsubscribed = "unsubscribed"
+)
+
+// purposes of the signed drip course tokens
+const (
+	dripConfirmPurpose     = "drip-confirm"
+	dripUnsubscribePurpose = "drip-unsubscribe"
+)
+
+// dripEmail is a step of a drip course sent to an enrollee, the offer of the
+// production follows the last step
+type dripEmail struct {
+	Name        string
+	Course      string
+	Step        int
+	Steps       int
+	Last        bool
+	Offer       *Production
+	Unsubscribe string
+}
+
+// dripUnsubscribeURL returns the link to stop the emails of an enrollment
+func dripUnsubscribeURL(enrollmentID int) string {
+	token := signedToken(dripUnsubscribePurpose, strconv.Itoa(enrollmentID))
+	return "https://focuscentric.com/course/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// dripConfirmURL returns the link starting an enrollment, it expires after
+// the confirmWindow
+func dripConfirmURL(e *DripEnrollment) string {
+	token := signedToken(dripConfirmPurpose, strconv.Itoa(e.ID)+"|"+strconv.FormatInt(e.EnrolledOn.Unix(), 10))
+	return "https://focuscentric.com/course/confirm?token=" + url.QueryEscape(token)
+}
+
+// parseDripConfirmToken returns the enrollment of a confirm token still valid
+func parseDripConfirmToken(token string) (int, error) {
+	value, err := parseSignedToken(dripConfirmPurpose, token)
+	if err != nil {
+		return 0, err
+	}
+
+	parts := strings.Split(value, "|")
+	if len(parts) != 2 {
+		return 0, errors.New("invalid token")
+	}
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > confirmWindow {
+		return 0, errors.New("expired token")
+	}
+	return strconv.Atoi(parts[0])
+}
+
+// loadDripStep parses a step of emails/drips/
+func loadDripStep(name string) (*emailTemplate, error) {
+	if !campaignName.MatchString(name) {
+		return nil, fmt.Errorf("invalid drip step name: %s", name)
+	}
+	return parseEmailTemplate("drip", "drips/"+name)
+}
+
+// sendDripStep sends the next step of an enrollment, unless the enrollee
+// bought the offered production in the meantime
+func sendDripStep(c *DripCourse, offer *Production, e *DripEnrollment) error {
+	if e.NextStep >= len(c.Steps) {
+		return stopDripEnrollment(e.ID, dripCompleted)
+	}
+
+	bought, err := hasPurchased(e.Email, c.ProductionID)
+	if err != nil {
+		return err
+	} else if bought {
+		return stopDripEnrollment(e.ID, dripPurchased)
+	}
+
+	step := c.Steps[e.NextStep]
+	t, err := loadDripStep(step.Template)
+	if err != nil {
+		return err
+	}
+
+	d := dripEmail{
+		Name:        e.Email,
+		Course:      c.Title,
+		Step:        step.Position + 1,
+		Steps:       len(c.Steps),
+		Last:        step.Position == len(c.Steps)-1,
+		Offer:       offer,
+		Unsubscribe: dripUnsubscribeURL(e.ID),
+	}
+	subject, html, text, err := t.render(d)
+	if err != nil {
+		return err
+	}
+
+	// the step is claimed before sending so the scheduler and the enrollment
+	// form never send it twice
+	claimed, err := claimDripStep(e.ID, step.Position)
+	if err != nil || !claimed {
+		return err
+	}
+
+	if err := sendMail(&email{From: mailSender(), To: e.Email, Subject: subject, HTML: html, Text: text, Headers: unsubscribeHeaders(d.Unsubscribe)}); err != nil {
+		return err
+	}
+	if d.Last {
+		return stopDripEnrollment(e.ID, dripCompleted)
+	}
+	return nil
+}
+
+// sendDueDripSteps sends the steps whose delay since the enrollment is over
+func sendDueDripSteps() {
+	enrollments, err := GetDueDripEnrollments()
+	if err != nil {
+		log.Printf("unable to get the due drip enrollments: %s", err)
+		return
+	}
+
+	courses := make(map[int]*DripCourse)
+	offers := make(map[int]*Production)
+	for _, e := range enrollments {
+		c, ok := courses[e.CourseID]
+		if !ok {
+			c, err = GetDripCourse(e.CourseID, "")
+			if err != nil {
+				log.Printf("unable to get drip course %d: %s", e.CourseID, err)
+				continue
+			}
+			courses[c.ID] = c
+
+			offers[c.ID], err = GetProduction(c.ProductionID, "")
+			if err != nil {
+				log.Printf("unable to get the production offered by drip course %d: %s", c.ID, err)
+			}
+		}
+		if !c.IsActive || offers[c.ID] == nil {
+			continue
+		}
+
+		if err := sendDripStep(c, offers[c.ID], e); err != nil {
+			log.Printf("unable to send step %d of drip course %d to %s: %s", e.NextStep, c.ID, e.Email, err)
+		}
+	}
+}
+
+// dripCourseHandler shows the enrollment form of a course, a POST enrolls the
+// email and asks to confirm it before the first step is sent
+func dripCourseHandler(w http.ResponseWriter, r *http.Request) {
+	slug := getID(r.URL.Path, "/course/")
+	course, err := GetDripCourse(-1, slug)
+	if err != nil || !course.IsActive {
+		log.Printf("error on dripCourseHandler: %v", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+	offer, err := GetProduction(course.ProductionID, "")
+	if err != nil {
+		log.Printf("error on dripCourseHandler: %s", err)
+		http.Redirect(w, r, "/error", http.StatusNotFound)
+		return
+	}
+
+	d := &pageData{
+		Title:             course.Title,
+		DripCourse:        course,
+		CurrentProduction: offer,
+		LatestEpisodes:    latestEpisodes[0:3],
+	}
+
+	if r.Method == http.MethodPost {
+		email := strings.TrimSpace(r.FormValue("email"))
+		if !strings.Contains(email, "@") || len(email) > 250 {
+			d.Message = "Cette adresse courriel n'est pas valide."
+		} else if !allowEmailRequest(r, "drip", email) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			d.Message = "Plusieurs inscriptions ont été demandées récemment, veuillez réessayer plus tard."
+		} else if e, err := enrollDrip(course.ID, email); err != nil {
+			log.Printf("error on dripCourseHandler: %s", err)
+			d.Message = "Votre inscription n'a pu être enregistrée, veuillez réessayer plus tard."
+		} else if e == nil {
+			d.Message = "Vous êtes déjà inscrit à ce cours, surveillez votre boîte de courriels."
+		} else if err := sendEmail(email, enrollmentEmail{Name: email, Course: course.Title, Confirm: dripConfirmURL(e)}); err != nil {
+			log.Printf("error on dripCourseHandler: %s", err)
+			d.Message = "Votre inscription n'a pu être enregistrée, veuillez réessayer plus tard."
+		} else {
+			d.Message = "Merci! Un courriel vous a été envoyé pour confirmer votre inscription au cours."
+		}
+	}
+
+	if err := render(w, r, "drip.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+// dripConfirmHandler starts the enrollment of the link emailed to confirm it,
+// the first step is sent right away when it has no delay
+func dripConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Cours par courriel", LatestEpisodes: latestEpisodes[0:3]}
+
+	id, err := parseDripConfirmToken(r.FormValue("token"))
+	confirmed := false
+	if err == nil {
+		confirmed, err = confirmDripEnrollment(id)
+	}
+	if err != nil {
+		log.Printf("error on dripConfirmHandler: %s", err)
+		d.Message = "Ce lien de confirmation n'est pas valide ou est expiré, inscrivez-vous à nouveau."
+	} else {
+		d.Message = "Votre inscription est confirmée, vous recevrez les leçons du cours par courriel."
+	}
+
+	if confirmed {
+		if err := sendFirstDripStep(id); err != nil {
+			log.Printf("unable to send the first step of drip enrollment %d: %s", id, err)
+		}
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+// sendFirstDripStep sends the first step of a confirmed enrollment when it has
+// no delay, the scheduler sends it otherwise
+func sendFirstDripStep(id int) error {
+	e, err := GetDripEnrollment(id)
+	if err != nil {
+		return err
+	}
+	course, err := GetDripCourse(e.CourseID, "")
+	if err != nil {
+		return err
+	}
+	if len(course.Steps) == 0 || course.Steps[0].DelayDays > 0 {
+		return nil
+	}
+
+	offer, err := GetProduction(course.ProductionID, "")
+	if err != nil {
+		return err
+	}
+	return sendDripStep(course, offer, e)
+}
+
+func dripUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Cours par courriel", LatestEpisodes: latestEpisodes[0:3]}
+
+	value, err := parseSignedToken(dripUnsubscribePurpose, r.FormValue("token"))
+	if err == nil {
+		var id int
+		id, err = strconv.Atoi(value)
+		if err == nil {
+			err = stopDripEnrollment(id, dripUnsubscribed)
+		}
+	}
+	if err != nil {
+		log.Printf("error on dripUnsubscribeHandler: %s", err)
+		d.Message = "Ce lien de désabonnement n'est pas valide."
+	} else {
+		d.Message = "Vous ne recevrez plus les courriels de ce cours."
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
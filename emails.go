@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// emailData is the typed data of an email, it names the template rendering it
+type emailData interface {
+	emailTemplate() string
+}
+
+// emailTemplate is an email rendered with the shared layout, in HTML and in
+// plain text, the text template also defines the subject
+type emailTemplate struct {
+	html   *template.Template
+	text   *texttemplate.Template
+	sample emailData
+}
+
+var emailTemplates map[string]*emailTemplate
+
+// parseEmailTemplate parses the HTML and text versions of the named files of
+// emails/ on top of the layout, the last one defining the content
+func parseEmailTemplate(names ...string) (*emailTemplate, error) {
+	htmlFiles := []string{"emails/layout.html"}
+	textFiles := []string{"emails/layout.txt"}
+	for _, n := range names {
+		htmlFiles = append(htmlFiles, "emails/"+n+".html")
+		textFiles = append(textFiles, "emails/"+n+".txt")
+	}
+
+	h, err := template.New("layout.html").Funcs(templateFuncs).ParseFiles(htmlFiles...)
+	if err != nil {
+		return nil, err
+	}
+	t, err := texttemplate.New("layout.txt").Funcs(texttemplate.FuncMap(templateFuncs)).ParseFiles(textFiles...)
+	if err != nil {
+		return nil, err
+	}
+	if t.Lookup("subject") == nil {
+		return nil, fmt.Errorf("%s does not define a subject", textFiles[len(textFiles)-1])
+	}
+	return &emailTemplate{html: h, text: t}, nil
+}
+
+// loadEmailTemplates parses the templates of every email in emails/, each one
+// is rendered with its sample data so a mistake fails at startup
+func loadEmailTemplates() error {
+	templates := make(map[string]*emailTemplate)
+	for _, sample := range emailSamples() {
+		name := sample.emailTemplate()
+
+		t, err := parseEmailTemplate(name)
+		if err != nil {
+			return err
+		}
+		t.sample = sample
+		templates[name] = t
+	}
+
+	emailTemplates = templates
+	for _, sample := range emailSamples() {
+		if _, _, _, err := renderEmail(sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderEmail returns the subject, HTML and text versions of an email
+func renderEmail(d emailData) (subject, html, text string, err error) {
+	t, ok := emailTemplates[d.emailTemplate()]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown email template: %s", d.emailTemplate())
+	}
+	return t.render(d)
+}
+
+// render returns the subject, HTML and text versions of the template
+func (t *emailTemplate) render(d interface{}) (subject, html, text string, err error) {
+	var s, h, b bytes.Buffer
+	if err := t.text.ExecuteTemplate(&s, "subject", d); err != nil {
+		return "", "", "", err
+	}
+	if err := t.html.Execute(&h, d); err != nil {
+		return "", "", "", err
+	}
+	if err := t.text.Execute(&b, d); err != nil {
+		return "", "", "", err
+	}
+	return strings.TrimSpace(s.String()), h.String(), b.String(), nil
+}
+
+// sendEmail renders an email and queues it for a recipient
+func sendEmail(to string, d emailData, attachments ...attachment) error {
+	subject, html, text, err := renderEmail(d)
+	if err != nil {
+		return err
+	}
+
+	return sendMail(&email{
+		From:        mailSender(),
+		To:          to,
+		Subject:     subject,
+		HTML:        html,
+		Text:        text,
+		Attachments: attachments,
+	})
+}
+
+// purchaseEmail confirms an order with its download links and invoice
+type purchaseEmail struct {
+	Name          string
+	Items         []purchaseEmailItem
+	InvoiceNumber string
+	InvoiceToken  string
+	GSTNumber     string
+	QSTNumber     string
+	Subtotal      string
+	Taxes         []TaxLine
+	Total         string
+	Free          bool
+}
+
+type purchaseEmailItem struct {
+	Title    string
+	Token    string
+	Amount   string
+	Seats    int
+	Gift     *Gift
+	Preorder *Production
+}
+
+func (purchaseEmail) emailTemplate() string { return "purchase" }
+
+// subscriptionEmail welcomes a new subscriber
+type subscriptionEmail struct {
+	Name  string
+	Plan  string
+	Price string
+	Token string
+}
+
+func (subscriptionEmail) emailTemplate() string { return "subscription" }
+
+// seatEmail invites a teammate to a team license seat
+type seatEmail struct {
+	Name  string
+	Owner string
+	Title string
+	Token string
+}
+
+func (seatEmail) emailTemplate() string { return "seat" }
+
+// giftEmail delivers a gift to its recipient
+type giftEmail struct {
+	Name    string
+	From    string
+	Title   string
+	Message string
+	Token   string
+}
+
+func (giftEmail) emailTemplate() string { return "gift" }
+
+// releaseEmail sends the download link of a pre-ordered production
+type releaseEmail struct {
+	Name  string
+	Title string
+	Token string
+	Seats int
+}
+
+func (releaseEmail) emailTemplate() string { return "release" }
+
+// newsletterEmail asks a new subscriber to confirm their email
+type newsletterEmail struct {
+	Name    string
+	Confirm string
+}
+
+func (newsletterEmail) emailTemplate() string { return "newsletter" }
+
+// enrollmentEmail asks to confirm the enrollment in a drip course
+type enrollmentEmail struct {
+	Name    string
+	Course  string
+	Confirm string
+}
+
+func (enrollmentEmail) emailTemplate() string { return "enrollment" }
+
+// episodeEmail announces a new episode to the owners of a production
+type episodeEmail struct {
+	Name         string
+	Production   string
+	ProductionID int
+	Episode      string
+	Slug         string
+	Token        string
+	OptOut       string
+}
+
+func (episodeEmail) emailTemplate() string { return "episode" }
+
+// contactEmail forwards a message of the contact form to support
+type contactEmail struct {
+	Message *ContactMessage
+}
+
+func (contactEmail) emailTemplate() string { return "contact" }
+
+// loginEmail sends a one-time sign in link to a customer
+type loginEmail struct {
+	Name string
+	Link string
+}
+
+func (loginEmail) emailTemplate() string { return "login" }
+
+// campaignEmail is a campaign of emails/campaigns/ sent to a subscriber
+type campaignEmail struct {
+	Name        string
+	Unsubscribe string
+}
+
+// emailSamples returns sample data for every email, used to check the
+// templates and to preview them
+func emailSamples() []emailData {
+	released := time.Now().AddDate(0, 1, 0)
+	return []emailData{
+		purchaseEmail{
+			Name: "client@exemple.com",
+			Items: []purchaseEmailItem{
+				{Title: "Apprendre Go", Token: "exemple", Amount: "49,00 $", Seats: 1},
+				{Title: "Node.js avancé (cadeau)", Token: "exemple", Amount: "39,00 $", Seats: 1, Gift: &Gift{RecipientEmail: "ami@exemple.com", DeliverOn: released}},
+				{Title: "Docker en équipe (5 postes)", Token: "exemple", Amount: "195,00 $", Seats: 5},
+				{Title: "Kubernetes (prévente)", Token: "exemple", Amount: "29,00 $", Seats: 1, Preorder: &Production{Title: "Kubernetes", ReleasedOn: released}},
+			},
+			InvoiceNumber: "FC-000123",
+			InvoiceToken:  "exemple",
+			GSTNumber:     "123456789 RT0001",
+			QSTNumber:     "1234567890 TQ0001",
+			Subtotal:      "312,00 $",
+			Taxes:         []TaxLine{{Name: "TPS", Rate: "5 %", Amount: 1560, Currency: defaultCurrency}, {Name: "TVQ", Rate: "9,975 %", Amount: 3112, Currency: defaultCurrency}},
+			Total:         "358,72 $",
+		},
+		subscriptionEmail{Name: "client@exemple.com", Plan: "Mensuel", Price: "19,00 $ / month", Token: "exemple"},
+		seatEmail{Name: "membre@exemple.com", Owner: "patron@exemple.com", Title: "Apprendre Go", Token: "exemple"},
+		giftEmail{Name: "ami@exemple.com", From: "client@exemple.com", Title: "Apprendre Go", Message: "Bonne formation!", Token: "exemple"},
+		releaseEmail{Name: "client@exemple.com", Title: "Kubernetes", Token: "exemple", Seats: 1},
+		newsletterEmail{Name: "client@exemple.com", Confirm: "https://focuscentric.com/newsletter/confirm?token=exemple"},
+		enrollmentEmail{Name: "client@exemple.com", Course: "Premiers pas en Go", Confirm: "https://focuscentric.com/course/confirm?token=exemple"},
+		loginEmail{Name: "client@exemple.com", Link: "https://focuscentric.com/login/confirm?token=exemple"},
+		contactEmail{Message: &ContactMessage{Name: "Client", Email: "client@exemple.com", Subject: "Facture", Message: "Bonjour,\nPourriez-vous m'envoyer une facture au nom de mon entreprise?", IP: "127.0.0.1", CreatedOn: time.Now()}},
+		episodeEmail{Name: "client@exemple.com", Production: "Apprendre Go", ProductionID: 1, Episode: "Les goroutines", Slug: "les-goroutines", Token: "exemple", OptOut: "https://focuscentric.com/episodes/optout?token=exemple"},
+	}
+}
+
+// emailPreviewHandler renders an email with its sample data, the text version
+// with ?format=text, or lists the emails without a name. Campaigns are
+// previewed as campaigns/{name} and drip course steps as drips/{name}
+func emailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	name := getID(r.URL.Path, "/admin/emails/preview/")
+	if strings.HasPrefix(name, "campaigns/") {
+		t, err := loadCampaign(strings.TrimPrefix(name, "campaigns/"))
+		if err != nil {
+			respond(w, r, http.StatusNotFound, err)
+			return
+		}
+		writeEmailPreview(w, r, t, campaignEmail{Name: "client@exemple.com", Unsubscribe: unsubscribeURL("client@exemple.com")})
+		return
+	} else if strings.HasPrefix(name, "drips/") {
+		t, err := loadDripStep(strings.TrimPrefix(name, "drips/"))
+		if err != nil {
+			respond(w, r, http.StatusNotFound, err)
+			return
+		}
+		writeEmailPreview(w, r, t, dripEmail{
+			Name:        "client@exemple.com",
+			Course:      "Go en 5 jours",
+			Step:        3,
+			Steps:       3,
+			Last:        true,
+			Offer:       &Production{ID: 1, Title: "Apprendre Go", Slug: "apprendre-go"},
+			Unsubscribe: dripUnsubscribeURL(1),
+		})
+		return
+	} else if len(name) == 0 {
+		var names []string
+		for n := range emailTemplates {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		respond(w, r, http.StatusOK, names)
+		return
+	}
+
+	t, ok := emailTemplates[name]
+	if !ok {
+		respond(w, r, http.StatusNotFound, fmt.Errorf("unknown email template: %s", name))
+		return
+	}
+
+	writeEmailPreview(w, r, t, t.sample)
+}
+
+func writeEmailPreview(w http.ResponseWriter, r *http.Request, t *emailTemplate, d interface{}) {
+	subject, html, text, err := t.render(d)
+	if err != nil {
+		respond(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Subject: %s\n\n%s", subject, text)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, html)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// episodeOptOutPurpose signs the links to stop the new episode emails
+const episodeOptOutPurpose = "episode-optout"
+
+// episodeRecipient is a customer who owns a production, Ref is the reference
+// of their download link
+type episodeRecipient struct {
+	Email string
+	Ref   string
+}
+
+// episodeOptOutURL returns the link to stop the new episode emails of a customer
+func episodeOptOutURL(email string) string {
+	return "https://focuscentric.com/episodes/optout?token=" + url.QueryEscape(signedToken(episodeOptOutPurpose, email))
+}
+
+// notifyNewEpisode emails every owner of the production the new episode and
+// their download link of the updated archive, once per customer
+func notifyNewEpisode(p *Production, e *Episode) {
+	recipients, err := getEpisodeRecipients(p.ID)
+	if err != nil {
+		log.Printf("unable to get the owners of production %d: %s", p.ID, err)
+		return
+	}
+
+	notified := make(map[string]bool)
+	for _, r := range recipients {
+		key := strings.ToLower(r.Email)
+		if notified[key] {
+			continue
+		}
+		notified[key] = true
+
+		emailData := episodeEmail{
+			Name:         r.Email,
+			Production:   p.Title,
+			ProductionID: p.ID,
+			Episode:      e.Title,
+			Slug:         e.Slug,
+			Token:        purchaseToken(r.Email, p.ID, r.Ref),
+			OptOut:       episodeOptOutURL(r.Email),
+		}
+		if err := sendEmail(r.Email, emailData); err != nil {
+			log.Printf("unable to notify %s of episode %d: %s", r.Email, e.ID, err)
+		}
+	}
+}
+
+func episodeOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Nouveaux épisodes", LatestEpisodes: latestEpisodes[0:3]}
+
+	email, err := parseSignedToken(episodeOptOutPurpose, r.FormValue("token"))
+	if err == nil {
+		err = optOutEpisodeNotifications(email)
+	}
+	if err != nil {
+		log.Printf("error on episodeOptOutHandler: %s", err)
+		d.Message = "Ce lien n'est pas valide."
+	} else {
+		d.Message = "Vous ne recevrez plus de courriel lors de l'ajout d'épisodes à vos formations."
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
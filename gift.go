@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const giftDateFormat = "2006-01-02"
+
+// parseGift reads the recipient, message and delivery date posted with a gift
+// purchase, an empty or past date delivers the gift right away
+func parseGift(r *http.Request) (*Gift, error) {
+	g := &Gift{
+		RecipientEmail: strings.TrimSpace(r.FormValue("recipient")),
+		Message:        strings.TrimSpace(r.FormValue("message")),
+		DeliverOn:      time.Now(),
+		Token:          randomToken(16),
+	}
+
+	if !strings.Contains(g.RecipientEmail, "@") {
+		return nil, errors.New("invalid gift recipient: " + g.RecipientEmail)
+	}
+	if len(g.Message) > 1000 {
+		g.Message = g.Message[:1000]
+	}
+
+	if d := r.FormValue("deliverOn"); len(d) > 0 {
+		t, err := time.ParseInLocation(giftDateFormat, d, time.Local)
+		if err != nil {
+			return nil, errors.New("invalid gift delivery date: " + d)
+		}
+		if t.After(g.DeliverOn) {
+			g.DeliverOn = t
+		}
+	}
+	return g, nil
+}
+
+// Pending returns true until the gift is emailed to its recipient
+func (g *Gift) Pending() bool {
+	return g.SentOn == nil
+}
+
+// errGiftNotReleased holds a gift of a production not released yet
+var errGiftNotReleased = errors.New("gift production is not released yet")
+
+// sendGift emails the recipient the download link of their gift, once the
+// production is released and its archive is published
+func sendGift(g *Gift) error {
+	p, err := GetProduction(g.ProductionID, "")
+	if err != nil {
+		return err
+	}
+	if p.IsPreorder() {
+		return errGiftNotReleased
+	}
+	if _, err := os.Stat(archivePath(p.ID)); err != nil {
+		return errGiftNotReleased
+	}
+
+	emailData := giftEmail{
+		Name:    g.RecipientEmail,
+		From:    g.FromEmail,
+		Title:   p.Title,
+		Message: g.Message,
+		Token:   purchaseToken(g.RecipientEmail, g.ProductionID, g.Token),
+	}
+
+	if err := sendEmail(g.RecipientEmail, emailData); err != nil {
+		return err
+	}
+	return setGiftSent(g.ID)
+}
+
+// deliverGifts sends the gifts whose delivery date has come
+func deliverGifts() {
+	gifts, err := GetDueGifts()
+	if err != nil {
+		log.Println("unable to get due gifts: " + err.Error())
+		return
+	}
+
+	for _, g := range gifts {
+		if err := sendGift(g); err == errGiftNotReleased {
+			continue
+		} else if err != nil {
+			log.Printf("unable to deliver gift %d: %s", g.ID, err)
+		}
+	}
+}
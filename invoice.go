@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Invoice is the official numbered receipt issued for an order
+type Invoice struct {
+	Number   int       `json:"number"`
+	OrderID  string    `json:"orderId"`
+	IssuedOn time.Time `json:"issuedOn"`
+}
+
+const companyName = "Focus Centric inc."
+
+// companyInfo returns the address lines and tax registration numbers printed
+// on invoices, the address lines are separated by | in COMPANY_ADDRESS
+func companyInfo() (address []string, gst string, qst string) {
+	if a := os.Getenv("COMPANY_ADDRESS"); len(a) > 0 {
+		address = strings.Split(a, "|")
+	}
+	return address, os.Getenv("GST_NUMBER"), os.Getenv("QST_NUMBER")
+}
+
+// FormattedNumber returns the invoice number as printed on the invoice
+func (i *Invoice) FormattedNumber() string {
+	return fmt.Sprintf("FC-%06d", i.Number)
+}
+
+// Filename returns the name of the PDF attachment
+func (i *Invoice) Filename() string {
+	return fmt.Sprintf("facture-%s.pdf", i.FormattedNumber())
+}
+
+// generateInvoicePDF renders the invoice of an order as a PDF document
+func generateInvoicePDF(inv *Invoice, o *Order) ([]byte, error) {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(tr("Facture "+inv.FormattedNumber()), false)
+	pdf.SetAuthor(companyName, false)
+	pdf.AddPage()
+
+	address, gst, qst := companyInfo()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.Cell(120, 8, tr(companyName))
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, "FACTURE", "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, l := range address {
+		pdf.CellFormat(0, 5, tr(l), "", 1, "L", false, 0, "")
+	}
+	if len(gst) > 0 {
+		pdf.CellFormat(0, 5, tr("No TPS : "+gst), "", 1, "L", false, 0, "")
+	}
+	if len(qst) > 0 {
+		pdf.CellFormat(0, 5, tr("No TVQ : "+qst), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	taxes := o.Taxes()
+
+	pdf.CellFormat(100, 5, tr("Facturé à : "+o.Email), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr("Facture no "+inv.FormattedNumber()), "", 1, "R", false, 0, "")
+	if len(taxes.Country) > 0 {
+		location := taxes.Country
+		if len(taxes.Province) > 0 {
+			location = taxes.Province + ", " + location
+		}
+		pdf.CellFormat(100, 5, tr(location), "", 0, "L", false, 0, "")
+	} else {
+		pdf.CellFormat(100, 5, "", "", 0, "L", false, 0, "")
+	}
+	pdf.CellFormat(0, 5, tr("Date : "+inv.IssuedOn.Format("2006-01-02")), "", 1, "R", false, 0, "")
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(228, 232, 235)
+	pdf.CellFormat(150, 7, "Description", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(0, 7, "Montant", "1", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	for _, l := range o.Lines {
+		pdf.CellFormat(150, 7, tr(l.Title), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 7, tr(l.Purchase.Subtotal.String()), "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	total := func(label, amount string) {
+		pdf.CellFormat(150, 6, tr(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(0, 6, tr(amount), "", 1, "R", false, 0, "")
+	}
+	total("Sous-total", o.Subtotal().String())
+	for _, t := range taxes.Lines(o.Currency()) {
+		total(t.Name+" ("+t.Rate+")", t.Display())
+	}
+	pdf.SetFont("Helvetica", "B", 10)
+	total("Total ("+o.Currency()+")", o.Total().String())
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 5, tr(fmt.Sprintf("Payé par carte de crédit le %s (transaction %s). Merci de votre achat!",
+		o.PurchasedDate.Format("2006-01-02"), o.ChargeID)), "", "L", false)
+
+	var b bytes.Buffer
+	if err := pdf.Output(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// maxSeats is the largest team license sold from the production pages, bigger
+// teams contact us for a site license
+const maxSeats = 50
+
+// teamLicense is a production bought for several seats, managed by its purchaser
+type teamLicense struct {
+	Key        string
+	Purchase   *Purchase
+	Production *Production
+	Seats      []*Seat
+}
+
+// Assigned returns the number of seats given to a teammate
+func (l *teamLicense) Assigned() int {
+	n := 0
+	for _, s := range l.Seats {
+		if len(s.Email) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// licenseTitle returns the order line title of a production bought for seats
+func licenseTitle(title string, seats int) string {
+	if seats <= 1 {
+		return title
+	}
+	return fmt.Sprintf("%s (licence d'équipe, %d postes)", title, seats)
+}
+
+// getTeamLicense returns the license identified by the purchaser's token
+func getTeamLicense(key string) (*teamLicense, error) {
+	email, prodID, chargeID, err := parsePurchaseToken(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := GetPurchase(email, prodID, chargeID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Seats <= 1 {
+		return nil, fmt.Errorf("purchase %d is not a team license", p.ID)
+	}
+
+	prod, err := GetProduction(prodID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	seats, err := GetSeats(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &teamLicense{Key: key, Purchase: p, Production: prod, Seats: seats}, nil
+}
+
+// findSeat returns the seat of the license by its id
+func (l *teamLicense) findSeat(id int) *Seat {
+	for _, s := range l.Seats {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// sendSeatInvitation emails a teammate the download link of their seat
+func sendSeatInvitation(l *teamLicense, s *Seat) {
+	emailData := seatEmail{
+		Name:  s.Email,
+		Owner: l.Purchase.Email,
+		Title: l.Production.Title,
+		Token: purchaseToken(s.Email, l.Production.ID, s.Token),
+	}
+
+	if err := sendEmail(s.Email, emailData); err != nil {
+		log.Println("unable to send seat invitation: " + err.Error())
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mailgun/mailgun-go"
+)
+
+const defaultSender = "Dominic de Focus Centric <dominic@focuscentric.com>"
+
+// email is a message sent to a single recipient, Text is derived from HTML
+// when empty, Headers are added to the standard ones. MessageID finds the
+// email in the bounce events, a random one is used when empty
+type email struct {
+	From        string
+	To          string
+	ReplyTo     string
+	Subject     string
+	HTML        string
+	Text        string
+	MessageID   string
+	Headers     map[string]string
+	Attachments []attachment
+}
+
+// attachment is a file attached to an email
+type attachment struct {
+	Filename string
+	Data     []byte
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(m *email) error
+}
+
+var mailer Mailer = &suppressionMailer{Mailer: newMailer()}
+
+// errSuppressed is returned when sending to an address of the suppression list
+var errSuppressed = errors.New("recipient is on the suppression list")
+
+// suppressionMailer refuses the addresses that bounced or complained, the
+// email is sent when the list is unavailable
+type suppressionMailer struct {
+	Mailer
+}
+
+func (s *suppressionMailer) Send(m *email) error {
+	suppressed, err := isSuppressed(m.To)
+	if err != nil {
+		log.Printf("unable to check the suppression list for %s, sending %q anyway: %s", m.To, m.Subject, err)
+	} else if suppressed {
+		return errSuppressed
+	}
+	return s.Mailer.Send(m)
+}
+
+// newMailer returns the mailer selected by MAIL_BACKEND: mailgun (default),
+// smtp or file
+func newMailer() Mailer {
+	switch strings.ToLower(os.Getenv("MAIL_BACKEND")) {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if len(addr) == 0 {
+			addr = "localhost:25"
+		}
+		return &smtpMailer{Addr: addr, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD")}
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if len(dir) == 0 {
+			dir = "mail"
+		}
+		return &fileMailer{Dir: dir}
+	}
+
+	domain := os.Getenv("MG_DOMAIN")
+	if len(domain) == 0 {
+		domain = "mg.focuscentric.com"
+	}
+	return &mailgunMailer{client: mailgun.NewMailgun(domain, os.Getenv("MG_KEY"), os.Getenv("MG_PUBKEY"))}
+}
+
+// newMessageID returns a unique Message-ID, without its angle brackets as the
+// email providers report it
+func newMessageID() string {
+	return randomToken(16) + "@focuscentric.com"
+}
+
+// mailSender returns the sender of our emails, MAIL_FROM or Dominic by default
+func mailSender() string {
+	if from := os.Getenv("MAIL_FROM"); len(from) > 0 {
+		return from
+	}
+	return defaultSender
+}
+
+// sendMail queues an email, it is sent right away when the outbox is
+// unavailable
+func sendMail(m *email) error {
+	err := queueMail(m)
+	if err == nil {
+		return nil
+	}
+	log.Printf("unable to queue email %q to %s, sending it now: %s", m.Subject, m.To, err)
+
+	err = mailer.Send(m)
+	if err != nil {
+		log.Printf("error sending email %q to %s: %s", m.Subject, m.To, err)
+	}
+	return err
+}
+
+// mailgunMailer sends emails through the Mailgun API
+type mailgunMailer struct {
+	client mailgun.Mailgun
+}
+
+func (mg *mailgunMailer) Send(m *email) error {
+	msg := mailgun.NewMessage(m.From, m.Subject, m.text(), "<"+m.To+">")
+	if len(m.HTML) > 0 {
+		msg.SetHtml(m.HTML)
+	}
+	if len(m.ReplyTo) > 0 {
+		msg.SetReplyTo(m.ReplyTo)
+	}
+	if len(m.MessageID) > 0 {
+		msg.AddHeader("Message-Id", "<"+m.MessageID+">")
+	}
+	for k, v := range m.Headers {
+		msg.AddHeader(k, v)
+	}
+	for _, a := range m.Attachments {
+		msg.AddReaderAttachment(a.Filename, ioutil.NopCloser(bytes.NewReader(a.Data)))
+	}
+
+	_, _, err := mg.client.Send(msg)
+	return err
+}
+
+// smtpMailer sends emails to an SMTP server, authenticating when a username
+// is configured
+type smtpMailer struct {
+	Addr     string
+	Username string
+	Password string
+}
+
+func (s *smtpMailer) Send(m *email) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if len(s.Username) > 0 {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{m.To}, msg)
+}
+
+// fileMailer writes emails as .eml files in a directory, for development
+type fileMailer struct {
+	Dir string
+}
+
+func (f *fileMailer) Send(m *email) error {
+	msg, err := m.bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := time.Now().Format("20060102-150405.000") + "-" + randomToken(4) + ".eml"
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), msg, 0644)
+}
+
+// text returns the plain text version of the email
+func (m *email) text() string {
+	if len(m.Text) > 0 {
+		return m.Text
+	}
+	return stripHTML(m.HTML)
+}
+
+// bytes returns the email as a MIME message, the text and HTML versions are
+// alternatives followed by the attachments
+func (m *email) bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, errors.New("email has no recipient")
+	}
+
+	var b bytes.Buffer
+	mixed := multipart.NewWriter(&b)
+
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	header("From", m.From)
+	header("To", m.To)
+	if len(m.ReplyTo) > 0 {
+		header("Reply-To", m.ReplyTo)
+	}
+	var keys []string
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header(k, m.Headers[k])
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	id := m.MessageID
+	if len(id) == 0 {
+		id = newMessageID()
+	}
+	header("Message-ID", "<"+id+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	b.WriteString("\r\n")
+
+	var alt bytes.Buffer
+	altw := multipart.NewWriter(&alt)
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.text()},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, p := range parts {
+		if len(p.body) == 0 {
+			continue
+		}
+		w, err := altw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(w, []byte(p.body))
+	}
+	altw.Close()
+
+	w, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + altw.Boundary()}})
+	if err != nil {
+		return nil, err
+	}
+	w.Write(alt.Bytes())
+
+	for _, a := range m.Attachments {
+		contentType := mime.TypeByExtension(filepath.Ext(a.Filename))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		w, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(w, a.Data)
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) {
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 76 {
+		w.Write([]byte(s[:76] + "\r\n"))
+		s = s[76:]
+	}
+	w.Write([]byte(s + "\r\n"))
+}
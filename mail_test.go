@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// smtpSink is an SMTP server accepting a single email, for the smtpMailer tests
+type smtpSink struct {
+	listener net.Listener
+	from     string
+	rcpt     []string
+	data     []byte
+	done     chan struct{}
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{listener: l, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpSink) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		switch verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]); {
+		case verb == "EHLO" || verb == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(cmd), "MAIL FROM:"):
+			s.from = strings.Trim(cmd[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(cmd), "RCPT TO:"):
+			s.rcpt = append(s.rcpt, strings.Trim(cmd[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case verb == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data = data.Bytes()
+			reply("250 OK")
+		case verb == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// readEmail parses a MIME message written by email.bytes, it returns the
+// headers, the decoded text and HTML versions and the attachments by filename
+func readEmail(t *testing.T, msg []byte) (mail.Header, string, string, map[string]string) {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text, html string
+	attachments := make(map[string]string)
+	mixed := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := mixed.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		if mediaType != "multipart/alternative" {
+			_, disposition, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+			attachments[disposition["filename"]] = decodePart(t, p)
+			continue
+		}
+
+		alt := multipart.NewReader(p, params["boundary"])
+		for {
+			ap, err := alt.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(ap.Header.Get("Content-Type"), "text/html") {
+				html = decodePart(t, ap)
+			} else {
+				text = decodePart(t, ap)
+			}
+		}
+	}
+	return m.Header, text, html, attachments
+}
+
+func decodePart(t *testing.T, p *multipart.Part) string {
+	b, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestEmailBytes(t *testing.T) {
+	m := &email{
+		From:    defaultSender,
+		To:      "client@example.com",
+		ReplyTo: "support@focuscentric.com",
+		Subject: "Confirmation d'achat",
+		HTML:    "<p>Merci de votre achat</p>",
+		Text:    "Merci de votre achat",
+		Headers: map[string]string{"List-Unsubscribe": "<https://focuscentric.com/newsletter/unsubscribe?token=abc>"},
+		Attachments: []attachment{
+			{Filename: "facture.pdf", Data: []byte("%PDF-1.4")},
+		},
+	}
+
+	msg, err := m.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, text, html, attachments := readEmail(t, msg)
+
+	for k, want := range map[string]string{
+		"From":             defaultSender,
+		"To":               "client@example.com",
+		"Reply-To":         "support@focuscentric.com",
+		"List-Unsubscribe": "<https://focuscentric.com/newsletter/unsubscribe?token=abc>",
+		"MIME-Version":     "1.0",
+	} {
+		if got := header.Get(k); got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("subject = %q (%v), want %q", subject, err, m.Subject)
+	}
+	if text != m.Text {
+		t.Errorf("text = %q, want %q", text, m.Text)
+	}
+	if html != m.HTML {
+		t.Errorf("html = %q, want %q", html, m.HTML)
+	}
+	if got := attachments["facture.pdf"]; got != "%PDF-1.4" {
+		t.Errorf("attachment facture.pdf = %q, want %%PDF-1.4", got)
+	}
+}
+
+func TestEmailBytesDerivesText(t *testing.T) {
+	m := &email{From: defaultSender, To: "client@example.com", Subject: "Bonjour", HTML: "<p>Bonjour <strong>Marie</strong></p>"}
+
+	msg, err := m.bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, text, _, _ := readEmail(t, msg)
+
+	if header.Get("Reply-To") != "" {
+		t.Errorf("unexpected Reply-To %q", header.Get("Reply-To"))
+	}
+	if !strings.Contains(text, "Bonjour Marie") {
+		t.Errorf("text = %q, want the HTML without its tags", text)
+	}
+}
+
+func TestEmailBytesWithoutRecipient(t *testing.T) {
+	if _, err := (&email{From: defaultSender, Subject: "Bonjour", Text: "Bonjour"}).bytes(); err == nil {
+		t.Error("expected an error for an email without recipient")
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	defer sink.listener.Close()
+
+	m := &email{
+		From:    defaultSender,
+		To:      "client@example.com",
+		Subject: "Votre lien de téléchargement",
+		Text:    "Bonjour,\n.\nVoici votre lien.",
+	}
+	s := &smtpMailer{Addr: sink.listener.Addr().String()}
+	if err := s.Send(m); err != nil {
+		t.Fatal(err)
+	}
+	<-sink.done
+
+	if sink.from != "dominic@focuscentric.com" {
+		t.Errorf("MAIL FROM = %q, want the sender address", sink.from)
+	}
+	if len(sink.rcpt) != 1 || sink.rcpt[0] != m.To {
+		t.Errorf("RCPT TO = %v, want [%s]", sink.rcpt, m.To)
+	}
+	header, text, _, _ := readEmail(t, sink.data)
+	if header.Get("To") != m.To {
+		t.Errorf("To = %q, want %q", header.Get("To"), m.To)
+	}
+	if strings.Replace(text, "\r\n", "\n", -1) != m.Text {
+		t.Errorf("text = %q, want %q", text, m.Text)
+	}
+}
+
+func TestSMTPMailerSendInvalidSender(t *testing.T) {
+	s := &smtpMailer{Addr: "127.0.0.1:1"}
+	if err := s.Send(&email{From: "not an address", To: "client@example.com", Text: "Bonjour"}); err == nil {
+		t.Error("expected an error for an invalid sender")
+	}
+}
@@ -18,6 +18,16 @@ var (
 	tags           map[string]string
 )
 
+var templateFuncs = template.FuncMap{
+	"money":     Money.String,
+	"decimal":   func(cents int) string { return formatDecimal(cents, 2) },
+	"withTaxes": withEstimatedTaxes,
+	"taxLines": func(price Money) []TaxLine {
+		return estimatedTaxes(price).Lines(price.Currency)
+	},
+	"maxTaxRate": maxTaxRate,
+}
+
 func loadTemplates() {
 	if templates == nil {
 		templates = make(map[string]*template.Template)
@@ -35,16 +45,19 @@ func loadTemplates() {
 
 	for _, page := range pages {
 		for _, layout := range layouts {
-			templates[filepath.Base(page)] = template.Must(template.New(page).ParseFiles(layout, page))
+			templates[filepath.Base(page)] = template.Must(template.New(page).Funcs(templateFuncs).ParseFiles(layout, page))
 		}
 	}
 }
 
-func render(w http.ResponseWriter, name string, data *pageData) (err error) {
+func render(w http.ResponseWriter, r *http.Request, name string, data *pageData) (err error) {
 	template, ok := templates[name]
 	if !ok {
 		err = fmt.Errorf("The template %s does not exists", name)
 	}
+	if s := currentSession(r); s != nil {
+		data.CSRF = s.CSRF()
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	template.ExecuteTemplate(w, "base", data)
 
@@ -84,6 +97,17 @@ func main() {
 	}
 	defer closeConnection()
 
+	if err := loadEmailTemplates(); err != nil {
+		log.Fatal(err)
+	}
+	tokenSecret()
+
+	if len(os.Args) > 1 {
+		code := runCommand(os.Args[1:])
+		closeConnection()
+		os.Exit(code)
+	}
+
 	le, err := getLatestEpisodes()
 	if err != nil {
 		log.Println("Cannot get latest episodes: " + err.Error())
@@ -108,49 +132,110 @@ func main() {
 	}
 
 	loadTemplates()
+	go runScheduler()
+	go runOutbox()
+
 	http.HandleFunc("/content/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, r.URL.Path[1:])
 	})
-	http.Handle("/collections/", weblog(http.HandlerFunc(collectionsHandler)))
-	http.Handle("/production/", weblog(http.HandlerFunc(productionHandler)))
-	http.Handle("/episode/", weblog(http.HandlerFunc(episodeHandler)))
+	http.Handle("/collections/", weblog(session(csrf(http.HandlerFunc(collectionsHandler)))))
+	http.Handle("/production/", weblog(session(csrf(http.HandlerFunc(productionHandler)))))
+	http.Handle("/episode/", weblog(session(csrf(http.HandlerFunc(episodeHandler)))))
+	http.Handle("/bundle/", weblog(session(csrf(http.HandlerFunc(bundleHandler)))))
 
-	http.Handle("/recent", weblog(http.HandlerFunc(recentHandler)))
+	http.Handle("/recent", weblog(session(csrf(http.HandlerFunc(recentHandler)))))
 
-	http.Handle("/blog/show/", weblog(http.HandlerFunc(blogEntryHandler)))
-	http.Handle("/blog/tag/", weblog(http.HandlerFunc(blogHandler)))
-	http.Handle("/blog", weblog(http.HandlerFunc(blogHandler)))
+	http.Handle("/blog/show/", weblog(session(csrf(http.HandlerFunc(blogEntryHandler)))))
+	http.Handle("/blog/tag/", weblog(session(csrf(http.HandlerFunc(blogHandler)))))
+	http.Handle("/blog", weblog(session(csrf(http.HandlerFunc(blogHandler)))))
 
-	http.Handle("/contact", weblog(http.HandlerFunc(contactHandler)))
+	http.Handle("/contact", weblog(session(csrf(http.HandlerFunc(contactHandler)))))
 
-	http.Handle("/docs/privacy", weblog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Handle("/docs/privacy", weblog(session(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		d := &pageData{Title: "Condition de vie privée", LatestEpisodes: latestEpisodes[0:3]}
-		if err := render(w, "privacy.html", d); err != nil {
+		if err := render(w, r, "privacy.html", d); err != nil {
 			log.Println(err.Error())
 		}
-	})))
-
-	http.Handle("/buy", weblog(http.HandlerFunc(buyHandler)))
-	http.Handle("/download/", weblog(http.HandlerFunc(downloadHandler)))
-
-	http.Handle("/api/episodes", weblog(auth(http.HandlerFunc(episodesHandler))))
-	http.Handle("/api/episodes/", weblog(auth(http.HandlerFunc(episodesHandler))))
-
-	http.Handle("/api/productions", weblog(auth(http.HandlerFunc(productionsHandler))))
-	http.Handle("/api/productions/", weblog(auth(http.HandlerFunc(productionsHandler))))
-
-	http.Handle("/error", weblog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	}))))
+
+	http.Handle("/currency", weblog(session(csrf(http.HandlerFunc(currencyHandler)))))
+
+	http.Handle("/buy", weblog(session(csrf(http.HandlerFunc(buyHandler)))))
+	http.Handle("/pay", weblog(session(csrf(http.HandlerFunc(payHandler)))))
+	http.Handle("/free", weblog(session(csrf(http.HandlerFunc(freeHandler)))))
+	http.Handle("/gift", weblog(session(csrf(http.HandlerFunc(giftHandler)))))
+	http.Handle("/team", weblog(session(csrf(http.HandlerFunc(teamHandler)))))
+	http.Handle("/license/", weblog(session(csrf(http.HandlerFunc(licenseHandler)))))
+	http.Handle("/license/seat", weblog(session(csrf(http.HandlerFunc(licenseSeatHandler)))))
+	http.Handle("/cart", weblog(session(csrf(http.HandlerFunc(cartHandler)))))
+	http.Handle("/cart/add", weblog(session(csrf(http.HandlerFunc(cartAddHandler)))))
+	http.Handle("/cart/remove", weblog(session(csrf(http.HandlerFunc(cartRemoveHandler)))))
+	http.Handle("/cart/checkout", weblog(session(csrf(http.HandlerFunc(cartCheckoutHandler)))))
+	http.Handle("/course/", weblog(session(csrf(http.HandlerFunc(dripCourseHandler)))))
+	http.Handle("/course/confirm", weblog(session(http.HandlerFunc(dripConfirmHandler))))
+	http.Handle("/course/unsubscribe", weblog(session(http.HandlerFunc(dripUnsubscribeHandler))))
+	http.Handle("/login", weblog(session(csrf(http.HandlerFunc(loginHandler)))))
+	http.Handle("/login/confirm", weblog(session(csrf(http.HandlerFunc(loginConfirmHandler)))))
+	http.Handle("/library", weblog(session(csrf(http.HandlerFunc(libraryHandler)))))
+	http.Handle("/logout", weblog(session(csrf(http.HandlerFunc(logoutHandler)))))
+	http.Handle("/episodes/optout", weblog(session(http.HandlerFunc(episodeOptOutHandler))))
+	http.Handle("/newsletter", weblog(session(csrf(http.HandlerFunc(newsletterHandler)))))
+	http.Handle("/newsletter/confirm", weblog(session(csrf(http.HandlerFunc(newsletterConfirmHandler)))))
+	http.Handle("/newsletter/unsubscribe", weblog(session(http.HandlerFunc(newsletterUnsubscribeHandler))))
+	http.Handle("/subscribe", weblog(session(csrf(http.HandlerFunc(subscribeHandler)))))
+	http.Handle("/subscription/", weblog(session(csrf(http.HandlerFunc(subscriptionHandler)))))
+	http.Handle("/subscription/cancel", weblog(session(csrf(http.HandlerFunc(subscriptionCancelHandler)))))
+	http.Handle("/webhooks/payments", weblog(http.HandlerFunc(paymentWebhookHandler)))
+	http.Handle("/webhooks/mailgun", weblog(http.HandlerFunc(mailgunWebhookHandler)))
+	http.Handle("/webhooks/email", weblog(http.HandlerFunc(emailWebhookHandler)))
+	http.Handle("/download/", weblog(session(csrf(http.HandlerFunc(downloadHandler)))))
+	http.Handle("/invoice/", weblog(session(csrf(http.HandlerFunc(invoiceHandler)))))
+
+	http.Handle("/api/episodes", weblog(session(auth(http.HandlerFunc(episodesHandler)))))
+	http.Handle("/api/episodes/", weblog(session(auth(http.HandlerFunc(episodesHandler)))))
+
+	http.Handle("/api/productions", weblog(session(auth(http.HandlerFunc(productionsHandler)))))
+	http.Handle("/api/productions/", weblog(session(auth(http.HandlerFunc(productionsHandler)))))
+
+	http.Handle("/api/bundles", weblog(session(auth(http.HandlerFunc(bundlesHandler)))))
+	http.Handle("/api/bundles/", weblog(session(auth(http.HandlerFunc(bundlesHandler)))))
+
+	http.Handle("/api/drips", weblog(session(auth(http.HandlerFunc(dripsHandler)))))
+	http.Handle("/api/drips/", weblog(session(auth(http.HandlerFunc(dripsHandler)))))
+
+	http.Handle("/api/sales", weblog(session(auth(http.HandlerFunc(salesHandler)))))
+	http.Handle("/api/sales/", weblog(session(auth(http.HandlerFunc(salesHandler)))))
+
+	http.Handle("/api/affiliates", weblog(session(auth(http.HandlerFunc(affiliatesHandler)))))
+	http.Handle("/api/affiliates/", weblog(session(auth(http.HandlerFunc(affiliatesHandler)))))
+	http.Handle("/api/affiliates/commissions", weblog(session(auth(http.HandlerFunc(commissionsHandler)))))
+
+	http.Handle("/api/instructors", weblog(session(auth(http.HandlerFunc(instructorsHandler)))))
+	http.Handle("/api/instructors/", weblog(session(auth(http.HandlerFunc(instructorsHandler)))))
+	http.Handle("/api/royalties", weblog(session(auth(http.HandlerFunc(royaltiesHandler)))))
+
+	http.Handle("/api/purchases", weblog(session(auth(http.HandlerFunc(purchasesHandler)))))
+	http.Handle("/api/accounting", weblog(session(auth(http.HandlerFunc(accountingHandler)))))
+	http.Handle("/api/emails", weblog(session(auth(http.HandlerFunc(emailsHandler)))))
+	http.Handle("/api/emails/", weblog(session(auth(http.HandlerFunc(emailsHandler)))))
+	http.Handle("/api/messages", weblog(session(auth(http.HandlerFunc(messagesHandler)))))
+	http.Handle("/api/suppressions", weblog(session(auth(http.HandlerFunc(suppressionsHandler)))))
+	http.Handle("/api/suppressions/", weblog(session(auth(http.HandlerFunc(suppressionsHandler)))))
+
+	http.Handle("/admin/emails/preview/", weblog(session(auth(http.HandlerFunc(emailPreviewHandler)))))
+
+	http.Handle("/error", weblog(session(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		d := &pageData{Title: "Une erreur est survenue"}
-		if err := render(w, "error.html", d); err != nil {
+		if err := render(w, r, "error.html", d); err != nil {
 			log.Println(err.Error())
 		}
-	})))
+	}))))
 
-	http.Handle("/", weblog(http.HandlerFunc(homeHandler)))
+	http.Handle("/", weblog(session(csrf(http.HandlerFunc(homeHandler)))))
 
 	port := os.Getenv("HTTP_PLATFORM_PORT")
 	if len(port) == 0 {
 		port = "8081"
 	}
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(http.ListenAndServe(":"+port, referral(http.DefaultServeMux)))
 }
@@ -0,0 +1,14 @@
+-- Sales taxes (GST/HST/PST/QST) charged on each purchase, amounts in cents.
+ALTER TABLE Purchases ADD
+    Subtotal INT NOT NULL CONSTRAINT DF_Purchases_Subtotal DEFAULT 0,
+    Country NVARCHAR(2) NOT NULL CONSTRAINT DF_Purchases_Country DEFAULT '',
+    Province NVARCHAR(2) NOT NULL CONSTRAINT DF_Purchases_Province DEFAULT '',
+    GST INT NOT NULL CONSTRAINT DF_Purchases_GST DEFAULT 0,
+    HST INT NOT NULL CONSTRAINT DF_Purchases_HST DEFAULT 0,
+    PST INT NOT NULL CONSTRAINT DF_Purchases_PST DEFAULT 0,
+    QST INT NOT NULL CONSTRAINT DF_Purchases_QST DEFAULT 0;
+GO
+
+-- Purchases made before taxes were charged have no taxes.
+UPDATE Purchases SET Subtotal = Amount;
+GO
@@ -0,0 +1,8 @@
+-- Sequential invoice numbers issued for each purchase.
+CREATE TABLE Invoices (
+    Number INT IDENTITY(1001, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_Invoices_Purchases REFERENCES Purchases(ID),
+    IssuedOn DATETIME NOT NULL,
+    CONSTRAINT UQ_Invoices_PurchaseID UNIQUE (PurchaseID)
+);
+GO
@@ -0,0 +1,14 @@
+-- Production prices in currencies other than CAD, amounts in cents.
+CREATE TABLE ProductionPrices (
+    ProductionID INT NOT NULL CONSTRAINT FK_ProductionPrices_Productions REFERENCES Productions(ID),
+    Currency NVARCHAR(3) NOT NULL,
+    Price INT NOT NULL,
+    SalesPrice INT NOT NULL CONSTRAINT DF_ProductionPrices_SalesPrice DEFAULT 0,
+    CONSTRAINT PK_ProductionPrices PRIMARY KEY (ProductionID, Currency)
+);
+GO
+
+-- Currency the purchase was charged in, all previous purchases were in CAD.
+ALTER TABLE Purchases ADD
+    Currency NVARCHAR(3) NOT NULL CONSTRAINT DF_Purchases_Currency DEFAULT 'CAD';
+GO
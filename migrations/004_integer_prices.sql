@@ -0,0 +1,18 @@
+-- Production prices are stored in cents instead of floating point dollars.
+-- The column order is kept since productions are read with SELECT *.
+ALTER TABLE Productions ADD PriceCents INT NULL, SalesPriceCents INT NULL;
+GO
+
+UPDATE Productions SET
+    PriceCents = CAST(ROUND(CAST(Price AS DECIMAL(12, 4)) * 100, 0) AS INT),
+    SalesPriceCents = CAST(ROUND(CAST(ISNULL(SalesPrice, 0) AS DECIMAL(12, 4)) * 100, 0) AS INT);
+GO
+
+UPDATE Productions SET SalesPrice = 0 WHERE SalesPrice IS NULL;
+ALTER TABLE Productions ALTER COLUMN Price INT NOT NULL;
+ALTER TABLE Productions ALTER COLUMN SalesPrice INT NOT NULL;
+GO
+
+UPDATE Productions SET Price = PriceCents, SalesPrice = SalesPriceCents;
+ALTER TABLE Productions DROP COLUMN PriceCents, SalesPriceCents;
+GO
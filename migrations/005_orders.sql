@@ -0,0 +1,34 @@
+-- Purchases paid by the same charge are grouped in an order.
+ALTER TABLE Purchases ADD OrderID NVARCHAR(32) NULL;
+GO
+
+UPDATE Purchases SET OrderID = ChargeID;
+ALTER TABLE Purchases ALTER COLUMN OrderID NVARCHAR(32) NOT NULL;
+CREATE INDEX IX_Purchases_OrderID ON Purchases(OrderID);
+GO
+
+-- Invoices are issued per order instead of per purchase.
+ALTER TABLE Invoices ADD OrderID NVARCHAR(32) NULL;
+GO
+
+UPDATE i SET OrderID = p.OrderID FROM Invoices i INNER JOIN Purchases p ON i.PurchaseID = p.ID;
+ALTER TABLE Invoices DROP CONSTRAINT UQ_Invoices_PurchaseID, FK_Invoices_Purchases;
+ALTER TABLE Invoices DROP COLUMN PurchaseID;
+ALTER TABLE Invoices ALTER COLUMN OrderID NVARCHAR(32) NOT NULL;
+ALTER TABLE Invoices ADD CONSTRAINT UQ_Invoices_OrderID UNIQUE (OrderID);
+GO
+
+-- Server side shopping carts, identified by the cart cookie.
+CREATE TABLE Carts (
+    ID NVARCHAR(32) NOT NULL PRIMARY KEY,
+    UpdatedOn DATETIME NOT NULL
+);
+GO
+
+CREATE TABLE CartItems (
+    CartID NVARCHAR(32) NOT NULL CONSTRAINT FK_CartItems_Carts REFERENCES Carts(ID) ON DELETE CASCADE,
+    ProductionID INT NOT NULL CONSTRAINT FK_CartItems_Productions REFERENCES Productions(ID),
+    AddedOn DATETIME NOT NULL,
+    CONSTRAINT PK_CartItems PRIMARY KEY (CartID, ProductionID)
+);
+GO
@@ -0,0 +1,22 @@
+-- Productions sold together at a bundle price, in cents.
+CREATE TABLE Bundles (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Slug NVARCHAR(150) NOT NULL CONSTRAINT UQ_Bundles_Slug UNIQUE,
+    Title NVARCHAR(250) NOT NULL,
+    Description NVARCHAR(MAX) NOT NULL,
+    Price INT NOT NULL,
+    IsActive BIT NOT NULL CONSTRAINT DF_Bundles_IsActive DEFAULT 1
+);
+GO
+
+CREATE TABLE BundleProductions (
+    BundleID INT NOT NULL CONSTRAINT FK_BundleProductions_Bundles REFERENCES Bundles(ID) ON DELETE CASCADE,
+    ProductionID INT NOT NULL CONSTRAINT FK_BundleProductions_Productions REFERENCES Productions(ID),
+    CONSTRAINT PK_BundleProductions PRIMARY KEY (BundleID, ProductionID)
+);
+GO
+
+-- Bundle a purchase was made through, 0 when bought on its own.
+ALTER TABLE Purchases ADD
+    BundleID INT NOT NULL CONSTRAINT DF_Purchases_BundleID DEFAULT 0;
+GO
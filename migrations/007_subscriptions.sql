@@ -0,0 +1,16 @@
+-- All-access subscriptions billed by the payment provider.
+CREATE TABLE Subscriptions (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Email NVARCHAR(250) NOT NULL,
+    [Plan] NVARCHAR(50) NOT NULL,
+    CustomerID NVARCHAR(100) NOT NULL,
+    SubscriptionID NVARCHAR(100) NOT NULL CONSTRAINT UQ_Subscriptions_SubscriptionID UNIQUE,
+    Status NVARCHAR(50) NOT NULL,
+    PeriodEnd DATETIME NOT NULL,
+    CreatedOn DATETIME NOT NULL,
+    CancelRequested BIT NOT NULL CONSTRAINT DF_Subscriptions_CancelRequested DEFAULT 0
+);
+GO
+
+CREATE INDEX IX_Subscriptions_Email ON Subscriptions(Email);
+GO
@@ -0,0 +1,17 @@
+-- Number of seats bought, a team license when above 1.
+ALTER TABLE Purchases ADD
+    Seats INT NOT NULL CONSTRAINT DF_Purchases_Seats DEFAULT 1;
+GO
+
+-- Seats of a team license, each one with its own download token.
+CREATE TABLE LicenseSeats (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_LicenseSeats_Purchases REFERENCES Purchases(ID) ON DELETE CASCADE,
+    Email NVARCHAR(250) NOT NULL CONSTRAINT DF_LicenseSeats_Email DEFAULT '',
+    Token NVARCHAR(64) NOT NULL CONSTRAINT UQ_LicenseSeats_Token UNIQUE,
+    Downloaded INT NOT NULL CONSTRAINT DF_LicenseSeats_Downloaded DEFAULT 0
+);
+GO
+
+CREATE INDEX IX_LicenseSeats_Email ON LicenseSeats(Email);
+GO
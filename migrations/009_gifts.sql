@@ -0,0 +1,22 @@
+-- Purchases offered to someone else, the buyer does not get the download.
+ALTER TABLE Purchases ADD
+    IsGift BIT NOT NULL CONSTRAINT DF_Purchases_IsGift DEFAULT 0;
+GO
+
+-- Gifts waiting to be delivered, or delivered when SentOn is set.
+CREATE TABLE Gifts (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_Gifts_Purchases REFERENCES Purchases(ID) ON DELETE CASCADE,
+    ProductionID INT NOT NULL,
+    FromEmail NVARCHAR(250) NOT NULL,
+    RecipientEmail NVARCHAR(250) NOT NULL,
+    Message NVARCHAR(1000) NOT NULL CONSTRAINT DF_Gifts_Message DEFAULT '',
+    DeliverOn DATETIME NOT NULL,
+    Token NVARCHAR(64) NOT NULL CONSTRAINT UQ_Gifts_Token UNIQUE,
+    SentOn DATETIME NULL,
+    Downloaded INT NOT NULL CONSTRAINT DF_Gifts_Downloaded DEFAULT 0
+);
+GO
+
+CREATE INDEX IX_Gifts_RecipientEmail ON Gifts(RecipientEmail);
+GO
@@ -0,0 +1,35 @@
+-- Time-boxed sales, a percentage off or a fixed price in cents of the base
+-- currency, applied to the productions of the sale between StartsOn and EndsOn.
+CREATE TABLE Sales (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Name NVARCHAR(250) NOT NULL,
+    StartsOn DATETIME NOT NULL,
+    EndsOn DATETIME NOT NULL,
+    [Percent] INT NOT NULL CONSTRAINT DF_Sales_Percent DEFAULT 0,
+    Price INT NOT NULL CONSTRAINT DF_Sales_Price DEFAULT 0
+);
+GO
+
+CREATE TABLE SaleProductions (
+    SaleID INT NOT NULL CONSTRAINT FK_SaleProductions_Sales REFERENCES Sales(ID) ON DELETE CASCADE,
+    ProductionID INT NOT NULL CONSTRAINT FK_SaleProductions_Productions REFERENCES Productions(ID),
+    CONSTRAINT PK_SaleProductions PRIMARY KEY (SaleID, ProductionID)
+);
+GO
+
+-- Price paid for each purchase and the reason of that price.
+CREATE TABLE PriceHistory (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_PriceHistory_Purchases REFERENCES Purchases(ID) ON DELETE CASCADE,
+    ProductionID INT NOT NULL,
+    Currency NVARCHAR(3) NOT NULL,
+    ListPrice INT NOT NULL,
+    PaidPrice INT NOT NULL,
+    SaleID INT NOT NULL CONSTRAINT DF_PriceHistory_SaleID DEFAULT 0,
+    Reason NVARCHAR(250) NOT NULL,
+    RecordedOn DATETIME NOT NULL
+);
+GO
+
+CREATE INDEX IX_PriceHistory_PurchaseID ON PriceHistory(PurchaseID);
+GO
@@ -0,0 +1,21 @@
+-- Bloggers earning a commission on the sales they refer with ?ref=Code.
+CREATE TABLE Affiliates (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Code NVARCHAR(50) NOT NULL CONSTRAINT UQ_Affiliates_Code UNIQUE,
+    Name NVARCHAR(250) NOT NULL,
+    Email NVARCHAR(250) NOT NULL,
+    CommissionPercent INT NOT NULL,
+    IsActive BIT NOT NULL CONSTRAINT DF_Affiliates_IsActive DEFAULT 1,
+    CreatedOn DATETIME NOT NULL
+);
+GO
+
+-- Affiliate a purchase is attributed to, 0 when none, and its commission in
+-- cents of the purchase currency.
+ALTER TABLE Purchases ADD
+    AffiliateID INT NOT NULL CONSTRAINT DF_Purchases_AffiliateID DEFAULT 0,
+    Commission INT NOT NULL CONSTRAINT DF_Purchases_Commission DEFAULT 0;
+GO
+
+CREATE INDEX IX_Purchases_AffiliateID ON Purchases(AffiliateID, PurchasedDate);
+GO
@@ -0,0 +1,38 @@
+-- Guest instructors paid a percentage of the net revenue of their productions.
+CREATE TABLE Instructors (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Name NVARCHAR(250) NOT NULL,
+    Email NVARCHAR(250) NOT NULL,
+    SharePercent INT NOT NULL,
+    CreatedOn DATETIME NOT NULL
+);
+GO
+
+CREATE TABLE ProductionInstructors (
+    ProductionID INT NOT NULL CONSTRAINT FK_ProductionInstructors_Productions REFERENCES Productions(ID),
+    InstructorID INT NOT NULL CONSTRAINT FK_ProductionInstructors_Instructors REFERENCES Instructors(ID) ON DELETE CASCADE,
+    CONSTRAINT PK_ProductionInstructors PRIMARY KEY (ProductionID, InstructorID)
+);
+GO
+
+-- Part of the purchase amount refunded, taxes included, in cents.
+ALTER TABLE Purchases ADD
+    Refunded INT NOT NULL CONSTRAINT DF_Purchases_Refunded DEFAULT 0;
+GO
+
+-- Royalty owed to an instructor for a purchase, amounts in cents of the
+-- purchase currency. Net is the subtotal less refunds and payment fees.
+CREATE TABLE Royalties (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_Royalties_Purchases REFERENCES Purchases(ID) ON DELETE CASCADE,
+    InstructorID INT NOT NULL CONSTRAINT FK_Royalties_Instructors REFERENCES Instructors(ID),
+    Currency NVARCHAR(3) NOT NULL,
+    Refunded INT NOT NULL,
+    Fees INT NOT NULL,
+    Net INT NOT NULL,
+    SharePercent INT NOT NULL,
+    Amount INT NOT NULL,
+    CalculatedOn DATETIME NOT NULL,
+    CONSTRAINT UQ_Royalties_Purchase_Instructor UNIQUE (PurchaseID, InstructorID)
+);
+GO
@@ -0,0 +1,10 @@
+-- Price in cents of a production sold before its release, 0 for the regular price.
+ALTER TABLE Productions ADD
+    PreorderPrice INT NOT NULL CONSTRAINT DF_Productions_PreorderPrice DEFAULT 0;
+GO
+
+-- pending for a production bought before its release, delivered once the
+-- download email is sent.
+ALTER TABLE Purchases ADD
+    PreorderStatus NVARCHAR(20) NOT NULL CONSTRAINT DF_Purchases_PreorderStatus DEFAULT '';
+GO
@@ -0,0 +1,5 @@
+-- How a production is sold: '' at a fixed price, 'pwyw' pay what you want with
+-- the current price as minimum, 'email' free in exchange of an email.
+ALTER TABLE Productions ADD
+    PricingMode NVARCHAR(20) NOT NULL CONSTRAINT DF_Productions_PricingMode DEFAULT '';
+GO
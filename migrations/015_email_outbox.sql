@@ -0,0 +1,29 @@
+-- Outgoing emails delivered by the outbox worker. Status is pending until the
+-- email is sent, or dead once MaxAttempts deliveries failed.
+CREATE TABLE EmailOutbox (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    FromAddress NVARCHAR(250) NOT NULL,
+    ToAddress NVARCHAR(250) NOT NULL,
+    ReplyTo NVARCHAR(250) NOT NULL CONSTRAINT DF_EmailOutbox_ReplyTo DEFAULT '',
+    Subject NVARCHAR(500) NOT NULL,
+    HTML NVARCHAR(MAX) NOT NULL,
+    Text NVARCHAR(MAX) NOT NULL,
+    Status NVARCHAR(20) NOT NULL,
+    Attempts INT NOT NULL CONSTRAINT DF_EmailOutbox_Attempts DEFAULT 0,
+    NextAttemptOn DATETIME NOT NULL,
+    LastError NVARCHAR(MAX) NOT NULL CONSTRAINT DF_EmailOutbox_LastError DEFAULT '',
+    CreatedOn DATETIME NOT NULL,
+    SentOn DATETIME NULL
+);
+GO
+
+CREATE INDEX IX_EmailOutbox_Status_NextAttemptOn ON EmailOutbox(Status, NextAttemptOn);
+GO
+
+CREATE TABLE EmailAttachments (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    EmailID INT NOT NULL CONSTRAINT FK_EmailAttachments_EmailOutbox REFERENCES EmailOutbox(ID) ON DELETE CASCADE,
+    Filename NVARCHAR(250) NOT NULL,
+    Data VARBINARY(MAX) NOT NULL
+);
+GO
@@ -0,0 +1,12 @@
+-- Mailing list subscribers. Status is pending until the email is confirmed
+-- (double opt-in), then confirmed or unsubscribed.
+CREATE TABLE Subscribers (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Email NVARCHAR(250) NOT NULL CONSTRAINT UQ_Subscribers_Email UNIQUE,
+    Status NVARCHAR(20) NOT NULL,
+    Source NVARCHAR(50) NOT NULL CONSTRAINT DF_Subscribers_Source DEFAULT '',
+    CreatedOn DATETIME NOT NULL,
+    ConfirmedOn DATETIME NULL,
+    UnsubscribedOn DATETIME NULL
+);
+GO
@@ -0,0 +1,7 @@
+-- Customers who do not want to be emailed when an episode is added to a
+-- production they own.
+CREATE TABLE EpisodeNotificationOptOuts (
+    Email NVARCHAR(250) NOT NULL PRIMARY KEY,
+    OptedOutOn DATETIME NOT NULL
+);
+GO
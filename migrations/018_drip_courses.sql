@@ -0,0 +1,40 @@
+-- Free mini-courses sent as a sequence of emails, ending with an offer for
+-- the paid production.
+CREATE TABLE DripCourses (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Slug NVARCHAR(250) NOT NULL CONSTRAINT UQ_DripCourses_Slug UNIQUE,
+    Title NVARCHAR(250) NOT NULL,
+    Description NVARCHAR(MAX) NOT NULL CONSTRAINT DF_DripCourses_Description DEFAULT '',
+    ProductionID INT NOT NULL CONSTRAINT FK_DripCourses_Productions REFERENCES Productions(ID),
+    IsActive BIT NOT NULL CONSTRAINT DF_DripCourses_IsActive DEFAULT 1
+);
+GO
+
+-- Emails of a course, sent DelayDays after the enrollment with the template
+-- emails/drips/{Template}.
+CREATE TABLE DripSteps (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    CourseID INT NOT NULL CONSTRAINT FK_DripSteps_DripCourses REFERENCES DripCourses(ID) ON DELETE CASCADE,
+    Position INT NOT NULL,
+    DelayDays INT NOT NULL,
+    Template NVARCHAR(100) NOT NULL,
+    CONSTRAINT UQ_DripSteps_Course_Position UNIQUE (CourseID, Position)
+);
+GO
+
+-- NextStep is the position of the next email to send while the enrollment is
+-- active, it is completed, purchased or unsubscribed once stopped.
+CREATE TABLE DripEnrollments (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    CourseID INT NOT NULL CONSTRAINT FK_DripEnrollments_DripCourses REFERENCES DripCourses(ID) ON DELETE CASCADE,
+    Email NVARCHAR(250) NOT NULL,
+    EnrolledOn DATETIME NOT NULL,
+    NextStep INT NOT NULL CONSTRAINT DF_DripEnrollments_NextStep DEFAULT 0,
+    Status NVARCHAR(20) NOT NULL,
+    StoppedOn DATETIME NULL,
+    CONSTRAINT UQ_DripEnrollments_Course_Email UNIQUE (CourseID, Email)
+);
+GO
+
+CREATE INDEX IX_DripEnrollments_Status ON DripEnrollments(Status);
+GO
@@ -0,0 +1,15 @@
+-- Addresses we no longer email after a permanent bounce or a complaint
+-- reported by the email provider.
+CREATE TABLE EmailSuppressions (
+    Email NVARCHAR(250) NOT NULL PRIMARY KEY,
+    Reason NVARCHAR(20) NOT NULL,
+    Detail NVARCHAR(MAX) NOT NULL CONSTRAINT DF_EmailSuppressions_Detail DEFAULT '',
+    SuppressedOn DATETIME NOT NULL
+);
+GO
+
+-- Set when the purchase confirmation bounced, so support knows the customer
+-- never received their download link.
+ALTER TABLE Purchases ADD
+    EmailUndelivered BIT NOT NULL CONSTRAINT DF_Purchases_EmailUndelivered DEFAULT 0;
+GO
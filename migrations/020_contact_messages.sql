@@ -0,0 +1,15 @@
+-- Messages sent with the contact form, the IP limits how many a visitor can
+-- send in an hour.
+CREATE TABLE ContactMessages (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Name NVARCHAR(100) NOT NULL,
+    Email NVARCHAR(250) NOT NULL,
+    Subject NVARCHAR(200) NOT NULL,
+    Message NVARCHAR(MAX) NOT NULL,
+    IP NVARCHAR(50) NOT NULL,
+    CreatedOn DATETIME NOT NULL
+);
+GO
+
+CREATE INDEX IX_ContactMessages_IP_CreatedOn ON ContactMessages(IP, CreatedOn);
+GO
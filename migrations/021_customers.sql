@@ -0,0 +1,20 @@
+-- Customer accounts, keyed by the email of their purchases. An account is
+-- created on the first sign in.
+CREATE TABLE Customers (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Email NVARCHAR(250) NOT NULL CONSTRAINT UQ_Customers_Email UNIQUE,
+    CreatedOn DATETIME NOT NULL,
+    LastLoginOn DATETIME NULL
+);
+GO
+
+-- One-time sign in links emailed to customers, only the SHA-256 of the token
+-- is kept.
+CREATE TABLE LoginTokens (
+    TokenHash NVARCHAR(64) NOT NULL PRIMARY KEY,
+    Email NVARCHAR(250) NOT NULL,
+    CreatedOn DATETIME NOT NULL,
+    ExpiresOn DATETIME NOT NULL,
+    UsedOn DATETIME NULL
+);
+GO
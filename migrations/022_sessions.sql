@@ -0,0 +1,12 @@
+-- Sessions of signed in customers and admins. ID is the SHA-256 of the
+-- session cookie, anonymous visitors have a cookie but no row.
+CREATE TABLE Sessions (
+    ID NVARCHAR(64) NOT NULL PRIMARY KEY,
+    Email NVARCHAR(250) NOT NULL,
+    CreatedOn DATETIME NOT NULL,
+    ExpiresOn DATETIME NOT NULL
+);
+GO
+
+CREATE INDEX IX_Sessions_ExpiresOn ON Sessions(ExpiresOn);
+GO
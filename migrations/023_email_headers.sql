@@ -0,0 +1,5 @@
+-- Extra headers of an outbox email, one "Name: value" per line, such as the
+-- List-Unsubscribe headers of the campaigns.
+ALTER TABLE EmailOutbox ADD
+    Headers NVARCHAR(MAX) NOT NULL CONSTRAINT DF_EmailOutbox_Headers DEFAULT '';
+GO
@@ -0,0 +1,12 @@
+-- Requests of the forms that send emails or messages, counted per IP and per
+-- email to limit how many a client can make in a window.
+CREATE TABLE RateLimitHits (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    Action NVARCHAR(50) NOT NULL,
+    Client NVARCHAR(250) NOT NULL,
+    CreatedOn DATETIME NOT NULL
+);
+GO
+
+CREATE INDEX IX_RateLimitHits_Action_Client_CreatedOn ON RateLimitHits(Action, Client, CreatedOn);
+GO
@@ -0,0 +1,14 @@
+-- Message-ID of the outbox emails, kept across the retries so the bounce
+-- events can be matched to the email they are about.
+ALTER TABLE EmailOutbox ADD
+    MessageID NVARCHAR(100) NOT NULL CONSTRAINT DF_EmailOutbox_MessageID DEFAULT '';
+GO
+
+-- Message-ID of the purchase confirmation, only its bounce flags the
+-- purchase as undelivered.
+ALTER TABLE Purchases ADD
+    ConfirmationMessageID NVARCHAR(100) NOT NULL CONSTRAINT DF_Purchases_ConfirmationMessageID DEFAULT '';
+GO
+
+CREATE INDEX IX_Purchases_ConfirmationMessageID ON Purchases(ConfirmationMessageID);
+GO
@@ -0,0 +1,34 @@
+-- Processing fee of a purchase as reported by the payment provider, in cents
+-- of the purchase currency. NULL for the purchases made before, their fees
+-- are estimated.
+ALTER TABLE Purchases ADD
+    PaymentFees INT NULL;
+GO
+
+-- Refunds of a purchase, taxes included, booked the day they were made.
+-- Purchases.Refunded stays the total refunded.
+CREATE TABLE Refunds (
+    ID INT IDENTITY(1, 1) NOT NULL PRIMARY KEY,
+    PurchaseID INT NOT NULL CONSTRAINT FK_Refunds_Purchases REFERENCES Purchases(ID) ON DELETE CASCADE,
+    Amount INT NOT NULL,
+    Currency NVARCHAR(3) NOT NULL,
+    RefundedOn DATETIME NOT NULL
+);
+GO
+
+CREATE INDEX IX_Refunds_RefundedOn ON Refunds(RefundedOn);
+GO
+
+-- The date of the past refunds is unknown, they are booked on the purchase.
+INSERT INTO Refunds (PurchaseID, Amount, Currency, RefundedOn)
+    SELECT ID, Refunded, Currency, PurchasedDate FROM Purchases WHERE Refunded > 0;
+GO
+
+-- The royalties no longer include the refunds, the statements take them off
+-- the month they are made.
+UPDATE r SET
+    Refunded = 0,
+    Net = CASE WHEN p.Subtotal > r.Fees THEN p.Subtotal - r.Fees ELSE 0 END,
+    Amount = CASE WHEN p.Subtotal > r.Fees THEN p.Subtotal - r.Fees ELSE 0 END * r.SharePercent / 100
+FROM Royalties r INNER JOIN Purchases p ON p.ID = r.PurchaseID;
+GO
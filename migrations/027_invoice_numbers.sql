@@ -0,0 +1,29 @@
+-- Invoice numbers come from a counter updated in the transaction of the
+-- invoice, an IDENTITY leaves gaps after a rollback or a restart.
+CREATE TABLE InvoiceNumbers (
+    ID INT NOT NULL PRIMARY KEY CONSTRAINT CK_InvoiceNumbers_ID CHECK (ID = 1),
+    LastNumber INT NOT NULL
+);
+GO
+
+INSERT INTO InvoiceNumbers (ID, LastNumber)
+    SELECT 1, COALESCE(MAX(Number), 1000) FROM Invoices;
+GO
+
+-- A column cannot lose its IDENTITY, the invoices are copied to a new table
+-- keeping the numbers already issued.
+CREATE TABLE InvoicesCopy (
+    Number INT NOT NULL PRIMARY KEY,
+    OrderID NVARCHAR(32) NOT NULL,
+    IssuedOn DATETIME NOT NULL
+);
+GO
+
+INSERT INTO InvoicesCopy (Number, OrderID, IssuedOn)
+    SELECT Number, OrderID, IssuedOn FROM Invoices;
+DROP TABLE Invoices;
+EXEC sp_rename 'InvoicesCopy', 'Invoices';
+GO
+
+ALTER TABLE Invoices ADD CONSTRAINT UQ_Invoices_OrderID UNIQUE (OrderID);
+GO
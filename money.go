@@ -0,0 +1,44 @@
+package main
+
+// Money is an amount in the minor units (cents) of its currency, it avoids the
+// rounding errors of storing prices as floats
+type Money struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// cad returns an amount of Canadian cents, the currency of the base prices
+func cad(cents int) Money {
+	return Money{Amount: cents, Currency: defaultCurrency}
+}
+
+// IsZero returns true for a free amount
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns the amount increased by a number of cents in the same currency
+func (m Money) Add(cents int) Money {
+	return Money{Amount: m.Amount + cents, Currency: m.Currency}
+}
+
+// Times returns the amount multiplied by a quantity
+func (m Money) Times(n int) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// Less returns true when the amount is lower than o, both being in the same currency
+func (m Money) Less(o Money) bool {
+	return m.Currency == o.Currency && m.Amount < o.Amount
+}
+
+// String returns the French formatted amount, i.e. 19,99 $
+func (m Money) String() string {
+	return formatMoney(m.Amount, m.Currency)
+}
+
+// accepts returns true when the amount is expressed in the currency or has
+// no currency specified, used to validate amounts received by the API
+func (m Money) accepts(currency string) bool {
+	return len(m.Currency) == 0 || m.Currency == currency
+}
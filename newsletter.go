@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statuses of a mailing list subscriber
+const (
+	subscriberPending      = "pending"
+	subscriberConfirmed    = "confirmed"
+	subscriberUnsubscribed = "unsubscribed"
+)
+
+// purposes of the signed newsletter tokens
+const (
+	confirmPurpose     = "newsletter-confirm"
+	unsubscribePurpose = "newsletter-unsubscribe"
+)
+
+// confirmWindow is how long a subscriber has to confirm their email
+const confirmWindow = 7 * 24 * time.Hour
+
+var campaignName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// confirmURL returns the double opt-in link of a subscriber, it expires after
+// the confirmWindow
+func confirmURL(email string) string {
+	token := signedToken(confirmPurpose, email+"|"+strconv.FormatInt(time.Now().Unix(), 10))
+	return "https://focuscentric.com/newsletter/confirm?token=" + url.QueryEscape(token)
+}
+
+// unsubscribeURL returns the one-click unsubscribe link of a subscriber
+func unsubscribeURL(email string) string {
+	return "https://focuscentric.com/newsletter/unsubscribe?token=" + url.QueryEscape(signedToken(unsubscribePurpose, email))
+}
+
+// unsubscribeHeaders lets mail clients show their own unsubscribe button, it
+// POSTs to the link without opening it (RFC 8058)
+func unsubscribeHeaders(link string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + link + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// parseConfirmToken returns the email of a confirm token still valid
+func parseConfirmToken(token string) (string, error) {
+	value, err := parseSignedToken(confirmPurpose, token)
+	if err != nil {
+		return "", err
+	}
+
+	i := strings.LastIndex(value, "|")
+	if i < 0 {
+		return "", errors.New("invalid token")
+	}
+	issued, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > confirmWindow {
+		return "", errors.New("expired token")
+	}
+	return value[:i], nil
+}
+
+// subscribeNewsletter adds an email to the mailing list and sends the double
+// opt-in confirmation, confirmed subscribers are left as is
+func subscribeNewsletter(email, source string) error {
+	sub, err := GetSubscriber(email)
+	if err != nil {
+		sub = &Subscriber{Email: email, Status: subscriberPending, Source: source}
+		if err := insertSubscriber(sub); err != nil {
+			return err
+		}
+	} else if sub.Status == subscriberConfirmed {
+		return nil
+	} else if err := setSubscriberStatus(email, subscriberPending); err != nil {
+		return err
+	}
+
+	return sendEmail(email, newsletterEmail{Name: email, Confirm: confirmURL(email)})
+}
+
+// loadCampaign parses a campaign of emails/campaigns/
+func loadCampaign(name string) (*emailTemplate, error) {
+	if !campaignName.MatchString(name) {
+		return nil, fmt.Errorf("invalid campaign name: %s", name)
+	}
+	return parseEmailTemplate("campaign", "campaigns/"+name)
+}
+
+// sendCampaign queues a campaign for every confirmed subscriber, or only for
+// the test address when it is set, and returns how many emails were queued
+func sendCampaign(name, test string) (int, error) {
+	t, err := loadCampaign(name)
+	if err != nil {
+		return 0, err
+	}
+
+	var emails []string
+	if len(test) > 0 {
+		emails = []string{test}
+	} else {
+		subscribers, err := GetConfirmedSubscribers()
+		if err != nil {
+			return 0, err
+		}
+		for _, sub := range subscribers {
+			emails = append(emails, sub.Email)
+		}
+	}
+
+	sent := 0
+	for _, to := range emails {
+		link := unsubscribeURL(to)
+		subject, html, text, err := t.render(campaignEmail{Name: to, Unsubscribe: link})
+		if err != nil {
+			return sent, err
+		}
+
+		err = sendMail(&email{From: mailSender(), To: to, Subject: subject, HTML: html, Text: text, Headers: unsubscribeHeaders(link)})
+		if err != nil {
+			log.Printf("unable to send campaign %s to %s: %s", name, to, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func newsletterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	d := &pageData{Title: "Infolettre", LatestEpisodes: latestEpisodes[0:3]}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !strings.Contains(email, "@") || len(email) > 250 {
+		d.Message = "Cette adresse courriel n'est pas valide."
+	} else if !allowEmailRequest(r, "newsletter", email) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		d.Message = "Plusieurs inscriptions ont été demandées récemment, veuillez réessayer plus tard."
+	} else if err := subscribeNewsletter(email, "site"); err != nil {
+		log.Printf("error on newsletterHandler: %s", err)
+		d.Message = "Votre inscription n'a pu être enregistrée, veuillez réessayer plus tard."
+	} else {
+		d.Message = "Merci! Un courriel vous a été envoyé pour confirmer votre inscription."
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+func newsletterConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Infolettre", LatestEpisodes: latestEpisodes[0:3]}
+
+	email, err := parseConfirmToken(r.URL.Query().Get("token"))
+	if err == nil {
+		err = setSubscriberStatus(email, subscriberConfirmed)
+	}
+	if err != nil {
+		log.Printf("error on newsletterConfirmHandler: %s", err)
+		d.Message = "Ce lien de confirmation n'est pas valide ou est expiré, inscrivez-vous à nouveau."
+	} else {
+		d.Message = "Votre inscription est confirmée, merci!"
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
+
+// newsletterUnsubscribeHandler unsubscribes in one click from the link of our
+// campaigns, mail clients may also POST to it
+func newsletterUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	d := &pageData{Title: "Infolettre", LatestEpisodes: latestEpisodes[0:3]}
+
+	email, err := parseSignedToken(unsubscribePurpose, r.FormValue("token"))
+	if err == nil {
+		err = setSubscriberStatus(email, subscriberUnsubscribed)
+	}
+	if err != nil {
+		log.Printf("error on newsletterUnsubscribeHandler: %s", err)
+		d.Message = "Ce lien de désabonnement n'est pas valide."
+	} else {
+		d.Message = "Vous êtes maintenant désabonné de notre infolettre."
+	}
+
+	if err := render(w, r, "newsletter.html", d); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Order groups the purchases paid by a single charge
+type Order struct {
+	ID            string
+	Email         string
+	ChargeID      string
+	PurchasedDate time.Time
+	Lines         []*OrderLine
+	// MessageID identifies the confirmation in the bounce events
+	MessageID string
+	// Fee is the processing fee of the charge, nil when it is not known
+	Fee *Money
+}
+
+// OrderLine is a production bought as part of an order
+type OrderLine struct {
+	Title    string
+	Purchase *Purchase
+	Gift     *Gift
+	item     *orderItem
+}
+
+// Subtotal returns the order amount before taxes
+func (o *Order) Subtotal() Money {
+	m := Money{Currency: o.Currency()}
+	for _, l := range o.Lines {
+		m = m.Add(l.Purchase.Subtotal.Amount)
+	}
+	return m
+}
+
+// Taxes returns the sum of the taxes charged on each purchase of the order
+func (o *Order) Taxes() Taxes {
+	var t Taxes
+	for _, l := range o.Lines {
+		t.Country = l.Purchase.Taxes.Country
+		t.Province = l.Purchase.Taxes.Province
+		t.GST += l.Purchase.Taxes.GST
+		t.HST += l.Purchase.Taxes.HST
+		t.PST += l.Purchase.Taxes.PST
+		t.QST += l.Purchase.Taxes.QST
+	}
+	return t
+}
+
+// Total returns the amount charged for the order
+func (o *Order) Total() Money {
+	m := Money{Currency: o.Currency()}
+	for _, l := range o.Lines {
+		m = m.Add(l.Purchase.Amount.Amount)
+	}
+	return m
+}
+
+// Currency returns the currency the order was charged in
+func (o *Order) Currency() string {
+	if len(o.Lines) == 0 {
+		return defaultCurrency
+	}
+	return o.Lines[0].Purchase.Amount.Currency
+}
+
+// orderItem is a production being bought at a given price, Seats is above 1
+// for a team license and Gift is set when it is offered to someone else.
+// ListPrice, Reason and SaleID are kept in the price history.
+type orderItem struct {
+	Production *Production
+	Title      string
+	Price      Money
+	ListPrice  Money
+	Reason     string
+	SaleID     int
+	BundleID   int
+	Seats      int
+	Gift       *Gift
+}
+
+// orderCurrency returns the currency if every production is sold in it,
+// otherwise the base currency is used for the whole order
+func orderCurrency(productions []*Production, currency string) string {
+	for _, p := range productions {
+		if !p.setCurrency(currency) {
+			currency = defaultCurrency
+			break
+		}
+	}
+
+	for _, p := range productions {
+		p.setCurrency(currency)
+	}
+	return currency
+}
+
+// productionItems returns the order items to buy productions at their current price
+func productionItems(productions []*Production, currency string) ([]*orderItem, error) {
+	var items []*orderItem
+	for _, p := range productions {
+		if !p.setCurrency(currency) {
+			return nil, errors.New("Production not sold in currency: " + currency)
+		}
+		reason, saleID := p.priceReason()
+		items = append(items, &orderItem{
+			Production: p,
+			Title:      p.Title,
+			Price:      p.CurrentPrice,
+			ListPrice:  p.ListPrice,
+			Reason:     reason,
+			SaleID:     saleID,
+		})
+	}
+	return items, nil
+}
+
+// teamLicenseItem returns the order item to buy a production for several seats
+func teamLicenseItem(p *Production, currency string, seats int) (*orderItem, error) {
+	if seats < 2 || seats > maxSeats {
+		return nil, fmt.Errorf("invalid number of seats: %d", seats)
+	}
+
+	items, err := productionItems([]*Production{p}, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	item := items[0]
+	item.Title = licenseTitle(p.Title, seats)
+	item.Price = item.Price.Times(seats)
+	item.ListPrice = item.ListPrice.Times(seats)
+	item.Reason = fmt.Sprintf("Licence d'équipe, %d postes (%s)", seats, item.Reason)
+	item.Seats = seats
+	return item, nil
+}
+
+// checkout charges the buyer for the items using the Stripe token posted by
+// Stripe Checkout and records one purchase per production, attributed to the
+// affiliate who referred the buyer
+func checkout(r *http.Request, items []*orderItem) (*Order, error) {
+	o, titles, err := newOrder(r, r.FormValue("stripeEmail"), items)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := payments.Charge(o.Total(), "Achat de "+strings.Join(titles, ", "), r.FormValue("stripeToken"), o.Email)
+	if err != nil {
+		return nil, err
+	}
+	o.ChargeID = pc.ID
+	o.Fee = pc.Fee
+
+	completeOrder(o)
+	return o, nil
+}
+
+// freeCheckout records a zero-amount order for productions given in exchange
+// of the visitor's email
+func freeCheckout(r *http.Request, email string, items []*orderItem) (*Order, error) {
+	for _, item := range items {
+		if !item.Price.IsZero() {
+			return nil, errors.New("production is not free: " + item.Production.Slug)
+		}
+	}
+
+	o, _, err := newOrder(r, email, items)
+	if err != nil {
+		return nil, err
+	}
+	o.ChargeID = "free-" + randomToken(8)
+
+	completeOrder(o)
+	return o, nil
+}
+
+// newOrder returns the order of the items with their taxes, it returns the
+// titles of the items for the charge description
+func newOrder(r *http.Request, email string, items []*orderItem) (*Order, []string, error) {
+	if len(items) == 0 {
+		return nil, nil, errors.New("nothing to buy")
+	}
+
+	currency := items[0].Price.Currency
+	o := &Order{ID: randomToken(16), Email: email}
+
+	country := r.FormValue("stripeBillingAddressCountryCode")
+	province := r.FormValue("stripeBillingAddressState")
+	affiliate := visitorAffiliate(r, o.Email)
+
+	var titles []string
+	for _, item := range items {
+		if item.Price.Currency != currency {
+			return nil, nil, errors.New("all items must be in the same currency")
+		}
+
+		taxes := computeTaxes(country, province, item.Price.Amount)
+
+		purchase := &Purchase{
+			OrderID:      o.ID,
+			ProductionID: item.Production.ID,
+			BundleID:     item.BundleID,
+			Seats:        item.Seats,
+			IsGift:       item.Gift != nil,
+			Email:        o.Email,
+			Subtotal:     item.Price,
+			Taxes:        taxes,
+			Amount:       item.Price.Add(taxes.Total()),
+		}
+		if item.Production.IsPreorder() {
+			purchase.Preorder = preorderPending
+		}
+
+		if affiliate != nil {
+			purchase.AffiliateID = affiliate.ID
+			purchase.Commission = affiliate.commission(item.Price)
+		}
+
+		o.Lines = append(o.Lines, &OrderLine{Title: item.Title, Purchase: purchase, Gift: item.Gift, item: item})
+		titles = append(titles, item.Title)
+	}
+	return o, titles, nil
+}
+
+// completeOrder records the purchases of a paid order and sends its confirmation
+func completeOrder(o *Order) {
+	o.MessageID = newMessageID()
+	if o.Fee != nil {
+		o.allocateFee(*o.Fee)
+	}
+	for _, l := range o.Lines {
+		l.Purchase.ChargeID = o.ChargeID
+		l.Purchase.MessageID = o.MessageID
+		if err := insertPurchase(l.Purchase); err != nil {
+			// the buyer has been charged, we still send the confirmation
+			log.Println("unable to save purchase: " + err.Error())
+			continue
+		}
+
+		pr := &PriceRecord{
+			PurchaseID:   l.Purchase.ID,
+			ProductionID: l.Purchase.ProductionID,
+			ListPrice:    l.item.ListPrice,
+			PaidPrice:    l.Purchase.Subtotal,
+			SaleID:       l.item.SaleID,
+			Reason:       l.item.Reason,
+		}
+		if err := insertPriceRecord(pr); err != nil {
+			log.Printf("unable to save the price history of purchase %d: %s", l.Purchase.ID, err)
+		}
+
+		if err := calculateRoyalties(l.Purchase, o.Total()); err != nil {
+			log.Printf("unable to calculate the royalties of purchase %d: %s", l.Purchase.ID, err)
+		}
+
+		if l.Purchase.Seats > 1 {
+			if err := insertSeats(l.Purchase.ID, l.Purchase.Seats); err != nil {
+				log.Printf("unable to create the seats of purchase %d: %s", l.Purchase.ID, err)
+			}
+		} else if l.Gift != nil {
+			l.Gift.PurchaseID = l.Purchase.ID
+			l.Gift.ProductionID = l.Purchase.ProductionID
+			l.Gift.FromEmail = o.Email
+			if err := insertGift(l.Gift); err != nil {
+				log.Printf("unable to save the gift of purchase %d: %s", l.Purchase.ID, err)
+			}
+		}
+		if err := stopDripEnrollmentsForPurchase(o.Email, l.Purchase.ProductionID); err != nil {
+			log.Printf("unable to stop the drip courses of %s: %s", o.Email, err)
+		}
+		o.PurchasedDate = l.Purchase.PurchasedDate
+	}
+
+	sendOrderConfirmation(o)
+	// the mailer drops the confirmation of a suppressed address
+	if suppressed, err := isSuppressed(o.Email); err == nil && suppressed {
+		if err := flagUndeliveredPurchases(o.MessageID); err != nil {
+			log.Printf("unable to flag the purchases of %s: %s", o.Email, err)
+		}
+	}
+}
+
+// allocateFee splits the processing fee of the charge between the purchases
+// in proportion of their amount, the last one takes the rounding
+func (o *Order) allocateFee(fee Money) {
+	total := o.Total()
+	allocated := 0
+	for i, l := range o.Lines {
+		var amount int
+		switch {
+		case i == len(o.Lines)-1:
+			amount = fee.Amount - allocated
+		case total.Amount > 0:
+			amount = fee.Amount * l.Purchase.Amount.Amount / total.Amount
+		}
+		allocated += amount
+		l.Purchase.Fees = &Money{Amount: amount, Currency: fee.Currency}
+	}
+}
+
+// sendOrderConfirmation emails the download links and the invoice of an order
+func sendOrderConfirmation(o *Order) {
+	emailData := purchaseEmail{Name: o.Email}
+	for _, l := range o.Lines {
+		emailData.Items = append(emailData.Items, purchaseEmailItem{
+			Title:  l.Title,
+			Token:  purchaseToken(o.Email, l.Purchase.ProductionID, o.ChargeID),
+			Amount: l.Purchase.Subtotal.String(),
+			Seats:  l.Purchase.Seats,
+			Gift:   l.Gift,
+		})
+		if l.item != nil && len(l.Purchase.Preorder) > 0 {
+			emailData.Items[len(emailData.Items)-1].Preorder = l.item.Production
+		}
+	}
+	emailData.InvoiceToken = emailData.Items[0].Token
+	emailData.Subtotal = o.Subtotal().String()
+	emailData.Taxes = o.Taxes().Lines(o.Currency())
+	emailData.Total = o.Total().String()
+	_, emailData.GSTNumber, emailData.QSTNumber = companyInfo()
+	emailData.Free = o.Total().IsZero()
+
+	var attachments []attachment
+	if emailData.Free {
+		// no invoice is issued for free downloads
+	} else if inv, err := insertInvoice(o.ID); err != nil {
+		log.Println("unable to issue invoice: " + err.Error())
+	} else {
+		emailData.InvoiceNumber = inv.FormattedNumber()
+
+		pdf, err := generateInvoicePDF(inv, o)
+		if err != nil {
+			log.Println("unable to generate invoice: " + err.Error())
+		} else {
+			attachments = append(attachments, attachment{Filename: inv.Filename(), Data: pdf})
+		}
+	}
+
+	subject, html, text, err := renderEmail(emailData)
+	if err == nil {
+		err = sendMail(&email{
+			From:        mailSender(),
+			To:          o.Email,
+			Subject:     subject,
+			HTML:        html,
+			Text:        text,
+			MessageID:   o.MessageID,
+			Attachments: attachments,
+		})
+	}
+	if err != nil {
+		log.Println("unable to send order confirmation: " + err.Error())
+	}
+}
+
+// bundleItems returns one order item per production of the bundle, the bundle
+// price is split between the productions in proportion of their regular price
+func bundleItems(b *Bundle) ([]*orderItem, error) {
+	if len(b.Productions) == 0 {
+		return nil, errors.New("bundle has no productions: " + b.Slug)
+	}
+
+	regular := 0
+	for _, p := range b.Productions {
+		p.setCurrency(b.Price.Currency)
+		regular += p.ListPrice.Amount
+	}
+
+	var items []*orderItem
+	allocated := 0
+	for i, p := range b.Productions {
+		var amount int
+		switch {
+		case i == len(b.Productions)-1:
+			amount = b.Price.Amount - allocated
+		case regular > 0:
+			amount = b.Price.Amount * p.ListPrice.Amount / regular
+		default:
+			amount = b.Price.Amount / len(b.Productions)
+		}
+		allocated += amount
+
+		items = append(items, &orderItem{
+			Production: p,
+			Title:      p.Title + " (" + b.Title + ")",
+			Price:      Money{Amount: amount, Currency: b.Price.Currency},
+			ListPrice:  p.ListPrice,
+			Reason:     "Forfait : " + b.Title,
+			BundleID:   b.ID,
+		})
+	}
+	return items, nil
+}
+
+// RegularPrice returns the sum of the regular price of the bundle productions
+func (b *Bundle) RegularPrice() Money {
+	m := Money{Currency: b.Price.Currency}
+	for _, p := range b.Productions {
+		p.setCurrency(b.Price.Currency)
+		m = m.Add(p.ListPrice.Amount)
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+// bundleProduction returns a production with a list price in Canadian cents
+func bundleProduction(id int, title string, price int) *Production {
+	return &Production{ID: id, Title: title, ListPrice: cad(price), CurrentPrice: cad(price)}
+}
+
+func TestBundleItemsSplitsInProportion(t *testing.T) {
+	b := &Bundle{
+		ID:    7,
+		Slug:  "web",
+		Title: "Forfait Web",
+		Price: cad(7000),
+		Productions: []*Production{
+			bundleProduction(1, "Go", 3000),
+			bundleProduction(2, "Docker", 2000),
+			bundleProduction(3, "Kubernetes", 5000),
+		},
+	}
+
+	items, err := bundleItems(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{2100, 1400, 3500}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for i, item := range items {
+		if item.Price != cad(want[i]) {
+			t.Errorf("item %d price = %v, want %v", i, item.Price, cad(want[i]))
+		}
+		if item.ListPrice != b.Productions[i].ListPrice {
+			t.Errorf("item %d list price = %v, want %v", i, item.ListPrice, b.Productions[i].ListPrice)
+		}
+		if item.BundleID != b.ID || item.Reason != "Forfait : Forfait Web" {
+			t.Errorf("item %d bundle = %d %q, want %d and the bundle reason", i, item.BundleID, item.Reason, b.ID)
+		}
+	}
+	if items[0].Title != "Go (Forfait Web)" {
+		t.Errorf("item title = %q, want the production and bundle titles", items[0].Title)
+	}
+}
+
+func TestBundleItemsLastTakesRounding(t *testing.T) {
+	b := &Bundle{
+		Title: "Trio",
+		Price: cad(1000),
+		Productions: []*Production{
+			bundleProduction(1, "A", 1500),
+			bundleProduction(2, "B", 1500),
+			bundleProduction(3, "C", 1500),
+		},
+	}
+
+	items, err := bundleItems(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, item := range items {
+		total += item.Price.Amount
+	}
+	if total != b.Price.Amount {
+		t.Errorf("items total %d, want the bundle price %d", total, b.Price.Amount)
+	}
+	if items[0].Price.Amount != 333 || items[2].Price.Amount != 334 {
+		t.Errorf("prices = %d, %d, %d, want 333, 333, 334", items[0].Price.Amount, items[1].Price.Amount, items[2].Price.Amount)
+	}
+}
+
+func TestBundleItemsFreeProductions(t *testing.T) {
+	b := &Bundle{
+		Title: "Gratuits",
+		Price: cad(1000),
+		Productions: []*Production{
+			bundleProduction(1, "A", 0),
+			bundleProduction(2, "B", 0),
+		},
+	}
+
+	items, err := bundleItems(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Price.Amount != 500 || items[1].Price.Amount != 500 {
+		t.Errorf("prices = %d, %d, want an even split", items[0].Price.Amount, items[1].Price.Amount)
+	}
+}
+
+func TestBundleItemsEmpty(t *testing.T) {
+	if _, err := bundleItems(&Bundle{Slug: "vide", Price: cad(1000)}); err == nil {
+		t.Error("expected an error for a bundle without productions")
+	}
+}
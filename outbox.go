@@ -0,0 +1,169 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statuses of an outbox email
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+)
+
+// outboxWake makes the outbox worker deliver right away instead of waiting for
+// its next poll
+var outboxWake = make(chan struct{}, 1)
+
+// outboxMaxAttempts returns how many times an email is tried before it is
+// dead, OUTBOX_MAX_ATTEMPTS or 8 by default
+func outboxMaxAttempts() int {
+	n, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS"))
+	if err != nil || n <= 0 {
+		n = 8
+	}
+	return n
+}
+
+// outboxBackoff returns the delay before the next attempt, doubling from one
+// minute after each failure up to 6 hours
+func outboxBackoff(attempts int) time.Duration {
+	d := time.Minute
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+// queueMail saves the email in the outbox and wakes the worker to deliver it,
+// the retries keep its Message-ID
+func queueMail(m *email) error {
+	if len(m.MessageID) == 0 {
+		m.MessageID = newMessageID()
+	}
+
+	now := time.Now()
+	e := &OutboxEmail{
+		From:          m.From,
+		To:            m.To,
+		ReplyTo:       m.ReplyTo,
+		Subject:       m.Subject,
+		HTML:          m.HTML,
+		Text:          m.text(),
+		Headers:       formatHeaders(m.Headers),
+		MessageID:     m.MessageID,
+		Status:        outboxPending,
+		NextAttemptOn: now,
+		CreatedOn:     now,
+	}
+	if err := insertOutboxEmail(e, m.Attachments); err != nil {
+		return err
+	}
+
+	wakeOutbox()
+	return nil
+}
+
+// formatHeaders returns the extra headers of an email as the lines saved in
+// the outbox
+func formatHeaders(headers map[string]string) string {
+	var lines []string
+	for k, v := range headers {
+		lines = append(lines, k+": "+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// parseHeaders returns the extra headers saved by formatHeaders
+func parseHeaders(s string) map[string]string {
+	if len(s) == 0 {
+		return nil
+	}
+	headers := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.Index(line, ": "); i > 0 {
+			headers[line[:i]] = line[i+2:]
+		}
+	}
+	return headers
+}
+
+// wakeOutbox asks the worker to deliver the outbox now
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverOutbox sends the pending emails whose next attempt has come, failed
+// emails are retried later until they are dead, right away when the recipient
+// is suppressed
+func deliverOutbox() {
+	emails, err := GetDueOutboxEmails()
+	if err != nil {
+		log.Println("unable to get outbox emails: " + err.Error())
+		return
+	}
+
+	for _, e := range emails {
+		attachments, err := getEmailAttachments(e.ID)
+		if err == nil {
+			err = mailer.Send(&email{
+				From:        e.From,
+				To:          e.To,
+				ReplyTo:     e.ReplyTo,
+				Subject:     e.Subject,
+				HTML:        e.HTML,
+				Text:        e.Text,
+				MessageID:   e.MessageID,
+				Headers:     parseHeaders(e.Headers),
+				Attachments: attachments,
+			})
+		}
+
+		if err == nil {
+			if err := setOutboxSent(e.ID); err != nil {
+				log.Printf("unable to mark email %d as sent: %s", e.ID, err)
+			}
+			continue
+		}
+
+		e.Attempts++
+		e.LastError = err.Error()
+		e.NextAttemptOn = time.Now().Add(outboxBackoff(e.Attempts))
+		if err == errSuppressed || e.Attempts >= outboxMaxAttempts() {
+			e.Status = outboxDead
+			log.Printf("email %d to %s is dead after %d attempts: %s", e.ID, e.To, e.Attempts, err)
+		}
+		if err := setOutboxFailed(e); err != nil {
+			log.Printf("unable to record the failure of email %d: %s", e.ID, err)
+		}
+	}
+}
+
+// runOutbox delivers the outbox when an email is queued and every
+// OUTBOX_INTERVAL, 30 seconds by default, for the retries
+func runOutbox() {
+	interval, err := time.ParseDuration(os.Getenv("OUTBOX_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	for {
+		deliverOutbox()
+		select {
+		case <-outboxWake:
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{1000, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	headers := unsubscribeHeaders("https://focuscentric.com/newsletter/unsubscribe?token=abc")
+	s := formatHeaders(headers)
+	if s != "List-Unsubscribe-Post: List-Unsubscribe=One-Click\nList-Unsubscribe: <https://focuscentric.com/newsletter/unsubscribe?token=abc>" {
+		t.Errorf("formatHeaders = %q, want sorted header lines", s)
+	}
+
+	got := parseHeaders(s)
+	if len(got) != len(headers) {
+		t.Fatalf("parseHeaders = %v, want %v", got, headers)
+	}
+	for k, v := range headers {
+		if got[k] != v {
+			t.Errorf("header %s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	if got := parseHeaders(formatHeaders(nil)); got != nil {
+		t.Errorf("parseHeaders of no headers = %v, want nil", got)
+	}
+}
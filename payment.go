@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go"
+	"github.com/stripe/stripe-go/charge"
+	"github.com/stripe/stripe-go/customer"
+	"github.com/stripe/stripe-go/event"
+	"github.com/stripe/stripe-go/sub"
+)
+
+// paymentProvider is the payment processor used to charge buyers and bill
+// subscriptions
+type paymentProvider interface {
+	// Charge charges the payment source
+	Charge(amount Money, desc, source, email string) (*providerCharge, error)
+	// Subscribe creates a recurring subscription to a provider plan
+	Subscribe(email, plan, source string, taxPercent float64) (*providerSubscription, error)
+	// CancelSubscription stops the subscription at the end of the paid period
+	CancelSubscription(customerID, subscriptionID string) error
+	// ParseEvent reads a webhook notification, nil is returned for events we
+	// do not handle
+	ParseEvent(r *http.Request) (*paymentEvent, error)
+}
+
+// providerCharge is a payment made at the provider, Fee is its processing fee
+// in the currency of the charge, nil when the provider did not report it
+type providerCharge struct {
+	ID  string
+	Fee *Money
+}
+
+// providerSubscription is the state of a subscription at the payment provider
+type providerSubscription struct {
+	CustomerID     string
+	SubscriptionID string
+	Status         string
+	PeriodEnd      time.Time
+}
+
+const (
+	subscriptionRenewed  = "renewed"
+	subscriptionUpdated  = "updated"
+	subscriptionCanceled = "canceled"
+	chargeRefunded       = "refunded"
+)
+
+// paymentEvent is a subscription change or a refund notified by the payment
+// provider, Refunded is the total amount refunded on the charge so far and
+// RefundedOn the date of the last refund
+type paymentEvent struct {
+	Type         string
+	Subscription providerSubscription
+	ChargeID     string
+	Refunded     Money
+	RefundedOn   time.Time
+}
+
+var payments paymentProvider = stripeProvider{}
+
+type stripeProvider struct{}
+
+func (stripeProvider) init() {
+	stripe.Key = os.Getenv("STRIPE")
+}
+
+func (s stripeProvider) Charge(amount Money, desc, source, email string) (*providerCharge, error) {
+	s.init()
+	params := &stripe.ChargeParams{}
+	params.Amount = uint64(amount.Amount)
+	params.Currency = stripe.Currency(strings.ToLower(amount.Currency))
+	params.Desc = desc
+	params.Email = email
+	params.SetSource(source)
+	params.Expand("balance_transaction")
+
+	ch, err := charge.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &providerCharge{ID: ch.ID}
+	if tx := ch.Tx; tx != nil && tx.Amount > 0 {
+		// the fee is in the currency of our balance, it is converted back at
+		// the rate of the charge
+		fee := tx.Fee
+		if !strings.EqualFold(string(tx.Currency), string(ch.Currency)) {
+			fee = fee * int64(ch.Amount) / tx.Amount
+		}
+		pc.Fee = &Money{Amount: int(fee), Currency: amount.Currency}
+	}
+	return pc, nil
+}
+
+func (s stripeProvider) Subscribe(email, plan, source string, taxPercent float64) (*providerSubscription, error) {
+	s.init()
+	cp := &stripe.CustomerParams{Email: email}
+	if err := cp.SetSource(source); err != nil {
+		return nil, err
+	}
+
+	c, err := customer.New(cp)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &stripe.SubParams{Customer: c.ID, Plan: plan, TaxPercent: taxPercent}
+	sb, err := sub.New(sp)
+	if err != nil {
+		return nil, err
+	}
+	return stripeSubscription(c.ID, sb), nil
+}
+
+func (s stripeProvider) CancelSubscription(customerID, subscriptionID string) error {
+	s.init()
+	_, err := sub.Cancel(subscriptionID, &stripe.SubParams{Customer: customerID, EndCancel: true})
+	return err
+}
+
+func (s stripeProvider) ParseEvent(r *http.Request) (*paymentEvent, error) {
+	s.init()
+
+	var posted stripe.Event
+	if err := parseBody(r.Body, &posted); err != nil {
+		return nil, err
+	}
+
+	// events are fetched back from Stripe so forged notifications are ignored
+	e, err := event.Get(posted.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Type {
+	case "charge.refunded":
+		var ch stripe.Charge
+		if err := json.Unmarshal(e.Data.Raw, &ch); err != nil {
+			return nil, err
+		}
+		refunded := Money{Amount: int(ch.AmountRefunded), Currency: strings.ToUpper(string(ch.Currency))}
+		refundedOn := e.Created
+		if ch.Refunds != nil {
+			for _, rf := range ch.Refunds.Values {
+				if rf.Created > 0 && (refundedOn == e.Created || rf.Created > refundedOn) {
+					refundedOn = rf.Created
+				}
+			}
+		}
+		return &paymentEvent{Type: chargeRefunded, ChargeID: ch.ID, Refunded: refunded, RefundedOn: time.Unix(refundedOn, 0)}, nil
+	case "invoice.payment_succeeded", "invoice.payment_failed":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(e.Data.Raw, &inv); err != nil {
+			return nil, err
+		}
+		if len(inv.Sub) == 0 || inv.Customer == nil {
+			return nil, nil
+		}
+
+		sb, err := sub.Get(inv.Sub, &stripe.SubParams{Customer: inv.Customer.ID})
+		if err != nil {
+			return nil, err
+		}
+
+		t := subscriptionRenewed
+		if e.Type == "invoice.payment_failed" {
+			t = subscriptionUpdated
+		}
+		return &paymentEvent{Type: t, Subscription: *stripeSubscription(inv.Customer.ID, sb)}, nil
+	case "customer.subscription.updated", "customer.subscription.deleted":
+		var sb stripe.Sub
+		if err := json.Unmarshal(e.Data.Raw, &sb); err != nil {
+			return nil, err
+		}
+		if sb.Customer == nil {
+			return nil, errors.New("subscription event without customer: " + e.ID)
+		}
+
+		t := subscriptionUpdated
+		if e.Type == "customer.subscription.deleted" {
+			t = subscriptionCanceled
+		}
+		return &paymentEvent{Type: t, Subscription: *stripeSubscription(sb.Customer.ID, &sb)}, nil
+	}
+	return nil, nil
+}
+
+func stripeSubscription(customerID string, sb *stripe.Sub) *providerSubscription {
+	return &providerSubscription{
+		CustomerID:     customerID,
+		SubscriptionID: sb.ID,
+		Status:         string(sb.Status),
+		PeriodEnd:      time.Unix(sb.PeriodEnd, 0),
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// preorderStatus is the Status of a production sold before its release
+const preorderStatus = "preorder"
+
+const (
+	preorderPending   = "pending"
+	preorderDelivered = "delivered"
+)
+
+// IsPreorder returns true while the production is sold before its release
+func (p *Production) IsPreorder() bool {
+	return strings.EqualFold(p.Status, preorderStatus)
+}
+
+// preorderPrice returns the pre-order price in the current currency, scaled
+// from the base currency price for the other currencies
+func (p *Production) preorderPrice() Money {
+	switch {
+	case p.PreorderPrice.Amount == 0:
+		return p.ListPrice
+	case p.ListPrice.Currency == p.PreorderPrice.Currency:
+		return p.PreorderPrice
+	case p.Price.Amount > 0:
+		return Money{Amount: p.ListPrice.Amount * p.PreorderPrice.Amount / p.Price.Amount, Currency: p.ListPrice.Currency}
+	}
+	return p.ListPrice
+}
+
+// applyPreorder lowers the current price to the pre-order price until the
+// production is released
+func (p *Production) applyPreorder() {
+	if !p.IsPreorder() {
+		return
+	}
+
+	if pp := p.preorderPrice(); pp.Less(p.CurrentPrice) {
+		p.CurrentPrice = pp
+	}
+}
+
+// archivePath returns the path of the downloadable archive of a production
+func archivePath(productionID int) string {
+	return fmt.Sprintf("prods/%d.zip", productionID)
+}
+
+// releaseDuePreorders emails the customers who pre-ordered a production once
+// it is no longer in pre-order and its archive is published
+func releaseDuePreorders() {
+	ids, err := getPreorderedProductions()
+	if err != nil {
+		log.Println("unable to get pre-ordered productions: " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		p, err := GetProduction(id, "")
+		if err != nil {
+			log.Printf("unable to get pre-ordered production %d: %s", id, err)
+			continue
+		}
+
+		if p.IsPreorder() {
+			continue
+		}
+		if _, err := os.Stat(archivePath(p.ID)); err != nil {
+			// released but the archive is not uploaded yet
+			continue
+		}
+
+		if err := releasePreorders(p); err != nil {
+			log.Printf("unable to release pre-orders of production %d: %s", id, err)
+		}
+	}
+}
+
+// releasePreorders emails the download link to the customers who pre-ordered
+// the production, pre-ordered gifts are then delivered by deliverGifts
+func releasePreorders(p *Production) error {
+	purchases, err := GetPendingPreorders(p.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, purchase := range purchases {
+		if !purchase.IsGift {
+			sendReleaseEmail(p, purchase)
+		}
+		if err := setPreorderStatus(purchase.ID, preorderDelivered); err != nil {
+			log.Printf("unable to mark pre-order %d as delivered: %s", purchase.ID, err)
+		}
+	}
+	return nil
+}
+
+// sendReleaseEmail sends the download link of a pre-ordered production, team
+// license purchasers get the link to invite their team instead
+func sendReleaseEmail(p *Production, purchase *Purchase) {
+	emailData := releaseEmail{
+		Name:  purchase.Email,
+		Title: p.Title,
+		Token: purchaseToken(purchase.Email, p.ID, purchase.ChargeID),
+		Seats: purchase.Seats,
+	}
+
+	if err := sendEmail(purchase.Email, emailData); err != nil {
+		log.Println("unable to send release email: " + err.Error())
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// pricing modes of a production, the default being a fixed price
+const (
+	pricingPayWhatYouWant = "pwyw"
+	pricingFreeWithEmail  = "email"
+)
+
+// IsPayWhatYouWant returns true when buyers choose their price, the current
+// price being the minimum
+func (p *Production) IsPayWhatYouWant() bool {
+	return p.PricingMode == pricingPayWhatYouWant
+}
+
+// IsFreeWithEmail returns true when the production is downloaded for free in
+// exchange of an email
+func (p *Production) IsFreeWithEmail() bool {
+	return p.PricingMode == pricingFreeWithEmail
+}
+
+// validPricingMode returns true for the pricing modes we support
+func validPricingMode(mode string) bool {
+	return mode == "" || mode == pricingPayWhatYouWant || mode == pricingFreeWithEmail
+}
+
+// parseAmount reads an amount typed by a visitor, i.e. 25, 25.50 or 25,50,
+// and returns it in cents
+func parseAmount(s string) (int, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	s = strings.TrimSpace(strings.TrimRight(s, "$€ "))
+	if len(s) == 0 {
+		return 0, errors.New("amount is required")
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 || (len(parts) == 2 && (len(parts[1]) == 0 || len(parts[1]) > 2)) {
+		return 0, errors.New("invalid amount: " + s)
+	}
+	// only digits, Atoi would accept a sign like -0.50
+	for _, p := range parts {
+		if strings.Trim(p, "0123456789") != "" {
+			return 0, errors.New("invalid amount: " + s)
+		}
+	}
+
+	units, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.New("invalid amount: " + s)
+	}
+
+	cents := 0
+	if len(parts) == 2 {
+		d := parts[1]
+		if len(d) == 1 {
+			d += "0"
+		}
+		cents, _ = strconv.Atoi(d)
+	}
+	return units*100 + cents, nil
+}
+
+// payWhatYouWantItem returns the order item to buy a production at the price
+// chosen by the buyer, which cannot be below the current price
+func payWhatYouWantItem(p *Production, currency string, amount int) (*orderItem, error) {
+	if !p.IsPayWhatYouWant() {
+		return nil, errors.New("production is not pay what you want: " + p.Slug)
+	}
+
+	items, err := productionItems([]*Production{p}, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	item := items[0]
+	minimum := item.Price
+	if amount <= 0 || amount < minimum.Amount {
+		return nil, errors.New("amount below the minimum price: " + formatMoney(amount, minimum.Currency))
+	}
+	item.Price = Money{Amount: amount, Currency: minimum.Currency}
+	item.Reason = "Prix choisi par l'acheteur (minimum " + minimum.String() + ")"
+	return item, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"25", 2500},
+		{"25.50", 2550},
+		{"25,50", 2550},
+		{"25,5", 2550},
+		{"0.99", 99},
+		{" 12 $", 1200},
+		{"12,00 €", 1200},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseAmount(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseAmountInvalid(t *testing.T) {
+	for _, in := range []string{"", " $", "abc", "25.", ".50", "25.505", "1.2.3", "25,50,00", "-5", "-0.50", "+5", "5.+1", "5.-1", "1e3"} {
+		if got, err := parseAmount(in); err == nil {
+			t.Errorf("parseAmount(%q) = %d, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rateLimitRetention is how long the requests are kept, the longest window of
+// the limits
+const rateLimitRetention = 24 * time.Hour
+
+// limits of the forms sending an email to the address entered, per IP and
+// per address within emailRequestWindow
+const (
+	ipEmailRequestLimit = 10
+	emailRequestLimit   = 3
+	emailRequestWindow  = time.Hour
+)
+
+// allowRequest records a request of an action by a client, an IP or an email,
+// and reports whether it stays within limit requests per window. Requests are
+// allowed when the limits are unavailable
+func allowRequest(action, client string, limit int, window time.Duration) bool {
+	n, err := countRateLimitHits(action, client, time.Now().Add(-window))
+	if err != nil {
+		log.Printf("unable to check the %s rate limit of %s: %s", action, client, err)
+		return true
+	}
+	if n >= limit {
+		log.Printf("%s rate limited for %s", action, client)
+		return false
+	}
+
+	if err := insertRateLimitHit(action, client); err != nil {
+		log.Printf("unable to record the %s request of %s: %s", action, client, err)
+	}
+	return true
+}
+
+// allowEmailRequest limits a form sending an email to the address entered,
+// both per IP and per address so a visitor cannot flood our server nor an
+// inbox from many IPs
+func allowEmailRequest(r *http.Request, action, email string) bool {
+	return allowRequest(action, clientIP(r), ipEmailRequestLimit, emailRequestWindow) &&
+		allowRequest(action, strings.ToLower(email), emailRequestLimit, emailRequestWindow)
+}
+
+// expireRateLimits deletes the requests older than the limits, it is a
+// scheduled job
+func expireRateLimits() {
+	if err := deleteRateLimitHits(time.Now().Add(-rateLimitRetention)); err != nil {
+		log.Println("unable to delete old rate limit hits: " + err.Error())
+	}
+}
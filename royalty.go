@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// paymentFees returns the processing fees of a purchase, PAYMENT_FEE_PERCENT
+// of its amount plus its share of the PAYMENT_FEE_FIXED cents charged once per
+// charge, 2.9 % + 30 cents by default
+func paymentFees(amount, chargeTotal Money) Money {
+	percent, err := strconv.ParseFloat(os.Getenv("PAYMENT_FEE_PERCENT"), 64)
+	if err != nil {
+		percent = 2.9
+	}
+	fixed, err := strconv.Atoi(os.Getenv("PAYMENT_FEE_FIXED"))
+	if err != nil {
+		fixed = 30
+	}
+
+	fees := int(float64(amount.Amount)*percent/100 + 0.5)
+	if chargeTotal.Amount > 0 {
+		fees += fixed * amount.Amount / chargeTotal.Amount
+	}
+	return Money{Amount: fees, Currency: amount.Currency}
+}
+
+// purchaseFees returns the processing fees of a purchase, those reported by
+// the payment provider or the estimate for the purchases made before
+func purchaseFees(p *Purchase, chargeTotal Money) Money {
+	if p.Fees != nil && p.Fees.Currency == p.Amount.Currency {
+		return *p.Fees
+	}
+	return paymentFees(p.Amount, chargeTotal)
+}
+
+// calculateRoyalties saves the royalty of each instructor of the purchased
+// production, the net is the subtotal less the fees. Refunds are taken off
+// the statement of the month they are made
+func calculateRoyalties(p *Purchase, chargeTotal Money) error {
+	instructors, err := getProductionInstructors(p.ProductionID)
+	if err != nil {
+		return err
+	}
+
+	currency := p.Amount.Currency
+	fees := purchaseFees(p, chargeTotal)
+
+	net := p.Subtotal.Amount - fees.Amount
+	if net < 0 {
+		net = 0
+	}
+
+	for _, i := range instructors {
+		share := i.SharePercent
+		if s, ok := getRoyaltySharePercent(p.ID, i.ID); ok {
+			share = s
+		}
+
+		r := &Royalty{
+			PurchaseID:   p.ID,
+			InstructorID: i.ID,
+			Refunded:     Money{Currency: currency},
+			Fees:         fees,
+			Net:          Money{Amount: net, Currency: currency},
+			SharePercent: share,
+			Amount:       Money{Amount: net * share / 100, Currency: currency},
+		}
+		if err := saveRoyalty(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRefund spreads the amount refunded on a charge across its purchases in
+// proportion of their amount, what each purchase gets refunded since the last
+// notification is saved as a refund dated refundedOn
+func recordRefund(chargeID string, refunded int, refundedOn time.Time) error {
+	purchases, err := GetChargePurchases(chargeID)
+	if err != nil {
+		return err
+	}
+	if len(purchases) == 0 {
+		return fmt.Errorf("no purchase for charge: %s", chargeID)
+	}
+
+	total := Money{Currency: purchases[0].Amount.Currency}
+	for _, p := range purchases {
+		total = total.Add(p.Amount.Amount)
+	}
+
+	allocated := 0
+	for i, p := range purchases {
+		var amount int
+		switch {
+		case i == len(purchases)-1:
+			amount = refunded - allocated
+		case total.Amount > 0:
+			amount = refunded * p.Amount.Amount / total.Amount
+		}
+		allocated += amount
+
+		if amount == p.Refunded.Amount {
+			continue
+		}
+		rf := &Refund{
+			PurchaseID: p.ID,
+			Amount:     Money{Amount: amount - p.Refunded.Amount, Currency: p.Amount.Currency},
+			RefundedOn: refundedOn,
+		}
+		if err := insertRefund(rf, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// royaltyLine is a sale on a royalty statement
+type royaltyLine struct {
+	Date         time.Time
+	Title        string
+	Subtotal     Money
+	Refunded     Money
+	Fees         Money
+	Net          Money
+	SharePercent int
+	Royalty      Money
+}
+
+// royaltyTotals returns the royalties owed per currency
+func royaltyTotals(lines []*royaltyLine) []Money {
+	sums := make(map[string]int)
+	for _, l := range lines {
+		sums[l.Royalty.Currency] += l.Royalty.Amount
+	}
+
+	var totals []Money
+	for c, amount := range sums {
+		totals = append(totals, Money{Amount: amount, Currency: c})
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	return totals
+}
+
+// writeRoyaltyCSV writes the royalty statement of an instructor as CSV
+func writeRoyaltyCSV(w io.Writer, i *Instructor, month time.Time, lines []*royaltyLine) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Formateur", "Mois", "Date", "Formation", "Devise", "Sous-total", "Remboursé", "Frais", "Net", "Part (%)", "Redevance"})
+	for _, l := range lines {
+		cw.Write([]string{
+			i.Name,
+			month.Format("2006-01"),
+			l.Date.Format("2006-01-02"),
+			l.Title,
+			l.Royalty.Currency,
+			formatDecimal(l.Subtotal.Amount, 2),
+			formatDecimal(l.Refunded.Amount, 2),
+			formatDecimal(l.Fees.Amount, 2),
+			formatDecimal(l.Net.Amount, 2),
+			strconv.Itoa(l.SharePercent),
+			formatDecimal(l.Royalty.Amount, 2),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// generateRoyaltyPDF renders the royalty statement of an instructor
+func generateRoyaltyPDF(i *Instructor, month time.Time, lines []*royaltyLine) ([]byte, error) {
+	pdf := gofpdf.New("L", "mm", "Letter", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(tr("Relevé de redevances "+month.Format("2006-01")), false)
+	pdf.SetAuthor(companyName, false)
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.Cell(160, 8, tr(companyName))
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, tr("RELEVÉ DE REDEVANCES"), "", 1, "R", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(160, 5, tr(i.Name+" <"+i.Email+">"), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 5, tr("Mois : "+month.Format("2006-01")), "", 1, "R", false, 0, "")
+	pdf.Ln(8)
+
+	widths := []float64{25, 90, 25, 25, 25, 25, 15, 0}
+	headers := []string{"Date", "Formation", "Sous-total", "Remboursé", "Frais", "Net", "Part", "Redevance"}
+
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(228, 232, 235)
+	for n, h := range headers {
+		align := "R"
+		if n < 2 {
+			align = "L"
+		}
+		ln := 0
+		if n == len(headers)-1 {
+			ln = 1
+		}
+		pdf.CellFormat(widths[n], 7, tr(h), "1", ln, align, true, 0, "")
+	}
+
+	pdf.SetFont("Helvetica", "", 9)
+	for _, l := range lines {
+		pdf.CellFormat(widths[0], 6, l.Date.Format("2006-01-02"), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 6, tr(l.Title), "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[2], 6, tr(l.Subtotal.String()), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 6, tr(l.Refunded.String()), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[4], 6, tr(l.Fees.String()), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[5], 6, tr(l.Net.String()), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[6], 6, fmt.Sprintf("%d %%", l.SharePercent), "1", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[7], 6, tr(l.Royalty.String()), "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(2)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	if len(lines) == 0 {
+		pdf.CellFormat(0, 6, tr("Aucune vente ce mois-ci."), "", 1, "L", false, 0, "")
+	}
+	for _, t := range royaltyTotals(lines) {
+		pdf.CellFormat(210, 6, tr("Total à payer ("+t.Currency+")"), "", 0, "R", false, 0, "")
+		pdf.CellFormat(0, 6, tr(t.String()), "", 1, "R", false, 0, "")
+	}
+
+	pdf.Ln(10)
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.MultiCell(0, 5, tr("Le net correspond au prix de vente avant taxes, moins la partie remboursée et les frais de traitement du paiement."), "", "L", false)
+
+	var b bytes.Buffer
+	if err := pdf.Output(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestPaymentFees(t *testing.T) {
+	t.Setenv("PAYMENT_FEE_PERCENT", "")
+	t.Setenv("PAYMENT_FEE_FIXED", "")
+
+	tests := []struct {
+		amount, chargeTotal int
+		want                int
+	}{
+		{10000, 10000, 320},
+		{4900, 4900, 172},
+		{2500, 10000, 80},
+		{7500, 10000, 240},
+		{0, 0, 0},
+	}
+	for _, tt := range tests {
+		got := paymentFees(cad(tt.amount), cad(tt.chargeTotal))
+		if got != cad(tt.want) {
+			t.Errorf("paymentFees(%d, %d) = %v, want %v", tt.amount, tt.chargeTotal, got, cad(tt.want))
+		}
+	}
+}
+
+func TestPaymentFeesFromEnv(t *testing.T) {
+	t.Setenv("PAYMENT_FEE_PERCENT", "3.5")
+	t.Setenv("PAYMENT_FEE_FIXED", "25")
+
+	if got := paymentFees(cad(10000), cad(10000)); got != cad(375) {
+		t.Errorf("paymentFees() = %v, want 3.5 %% + 25 cents", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// loadPricing loads the additional currency prices and the best running sale
+// of a production and applies the sale to its current price
+func loadPricing(p *Production) error {
+	prices, err := getProductionPrices(p.ID)
+	if err != nil {
+		return err
+	}
+	p.Prices = prices
+
+	sales, err := getActiveSales(p.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sales {
+		if p.Sale == nil || s.priceFor(p.Price, p.ListPrice).Less(p.Sale.priceFor(p.Price, p.ListPrice)) {
+			p.Sale = s
+		}
+	}
+	p.applyDiscounts()
+	return nil
+}
+
+// priceFor returns the sale price of a list price, base is the production
+// price in the base currency used to scale fixed prices to other currencies
+func (s *Sale) priceFor(base, list Money) Money {
+	switch {
+	case s.Percent > 0:
+		return Money{Amount: list.Amount * (100 - s.Percent) / 100, Currency: list.Currency}
+	case s.Price.Amount > 0 && list.Currency == s.Price.Currency:
+		return s.Price
+	case s.Price.Amount > 0 && base.Amount > 0:
+		return Money{Amount: list.Amount * s.Price.Amount / base.Amount, Currency: list.Currency}
+	}
+	return list
+}
+
+// validate checks a sale received by the API
+func (s *Sale) validate() error {
+	if len(s.Name) == 0 {
+		return errors.New("sale name is required")
+	}
+	if !s.EndsOn.After(s.StartsOn) {
+		return errors.New("sale must end after it starts")
+	}
+	if !s.Price.accepts(defaultCurrency) {
+		return fmt.Errorf("sale price must be in %s", defaultCurrency)
+	}
+	if (s.Percent > 0) == (s.Price.Amount > 0) {
+		return errors.New("sale needs either a percent or a price")
+	}
+	if s.Percent < 0 || s.Percent >= 100 || s.Price.Amount < 0 {
+		return errors.New("invalid sale discount")
+	}
+	return nil
+}
+
+// applyDiscounts lowers the current price to the sale or pre-order price
+func (p *Production) applyDiscounts() {
+	p.applySale()
+	p.applyPreorder()
+}
+
+// applySale lowers the current price to the sale price, a manual SalesPrice
+// lower than the sale is kept
+func (p *Production) applySale() {
+	if p.Sale == nil {
+		return
+	}
+
+	if sp := p.Sale.priceFor(p.Price, p.ListPrice); sp.Less(p.CurrentPrice) {
+		p.CurrentPrice = sp
+	}
+}
+
+// priceReason explains the current price of a production, with the id of the
+// sale applied if any
+func (p *Production) priceReason() (string, int) {
+	switch {
+	case p.IsPreorder() && p.OnSale() && p.CurrentPrice == p.preorderPrice():
+		return "Prix de prévente", 0
+	case p.Sale != nil && p.OnSale() && p.CurrentPrice == p.Sale.priceFor(p.Price, p.ListPrice):
+		return "Promotion : " + p.Sale.Name, p.Sale.ID
+	case p.OnSale():
+		return "Prix réduit", 0
+	}
+	return "Prix régulier", 0
+}
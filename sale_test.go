@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestSalePriceFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		sale       Sale
+		base, list Money
+		want       Money
+	}{
+		{"percent", Sale{Percent: 25}, cad(4000), cad(4000), cad(3000)},
+		{"percent rounds down", Sale{Percent: 15}, cad(4999), cad(4999), cad(4249)},
+		{"percent in another currency", Sale{Percent: 20}, cad(4000), Money{Amount: 3000, Currency: "USD"}, Money{Amount: 2400, Currency: "USD"}},
+		{"fixed price", Sale{Price: cad(1900)}, cad(4000), cad(4000), cad(1900)},
+		{"fixed price scaled", Sale{Price: cad(2000)}, cad(4000), Money{Amount: 3000, Currency: "USD"}, Money{Amount: 1500, Currency: "USD"}},
+		{"fixed price without base", Sale{Price: cad(2000)}, cad(0), Money{Amount: 3000, Currency: "USD"}, Money{Amount: 3000, Currency: "USD"}},
+		{"no discount", Sale{}, cad(4000), cad(4000), cad(4000)},
+	}
+	for _, tt := range tests {
+		if got := tt.sale.priceFor(tt.base, tt.list); got != tt.want {
+			t.Errorf("%s: priceFor(%v, %v) = %v, want %v", tt.name, tt.base, tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// scheduledJobs run in the background every SCHEDULER_INTERVAL
+var scheduledJobs = []func(){
+	deliverGifts,
+	releaseDuePreorders,
+	sendDueDripSteps,
+	expireSessions,
+	expireRateLimits,
+}
+
+// runScheduler runs the scheduled jobs every SCHEDULER_INTERVAL, 10 minutes by
+// default. GIFT_INTERVAL, which scheduled the gift deliveries before, is still
+// read when SCHEDULER_INTERVAL is not set
+func runScheduler() {
+	setting := os.Getenv("SCHEDULER_INTERVAL")
+	if len(setting) == 0 {
+		setting = os.Getenv("GIFT_INTERVAL")
+	}
+
+	interval, err := time.ParseDuration(setting)
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	for {
+		for _, job := range scheduledJobs {
+			job()
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "fc_session"
+	// sessionDuration is how long a customer or an admin stays signed in
+	sessionDuration = 30 * 24 * time.Hour
+)
+
+type sessionKey struct{}
+
+// Session is the visitor's session, Email is set once signed in. The cookie
+// holds ID, only its hash is stored with the signed in email
+type Session struct {
+	ID    string
+	Email string
+}
+
+// CSRF returns the token our forms post back, it is bound to the session so
+// it changes when the customer signs in or out
+func (s *Session) CSRF() string {
+	mac := hmac.New(sha256.New, tokenSecret())
+	mac.Write([]byte("csrf|" + s.ID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IsAdmin returns whether the signed in email is one of ADMIN_EMAILS, a comma
+// separated list
+func (s *Session) IsAdmin() bool {
+	if len(s.Email) == 0 {
+		return false
+	}
+	for _, a := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if strings.EqualFold(strings.TrimSpace(a), s.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// isHTTPS returns whether the visitor reached us over HTTPS, directly or
+// through one of our proxies
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return fromTrustedProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// setSessionCookie sets the session cookie, it is only sent over HTTPS when
+// the visitor uses it so the plain HTTP development server keeps working
+func setSessionCookie(w http.ResponseWriter, r *http.Request, id string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge > 0 {
+		c.Expires = time.Now().Add(maxAge)
+	}
+	http.SetCookie(w, c)
+}
+
+// newSession starts an anonymous session for the visitor
+func newSession(w http.ResponseWriter, r *http.Request) *Session {
+	s := &Session{ID: randomToken(32)}
+	setSessionCookie(w, r, s.ID, 0)
+	return s
+}
+
+// session loads the visitor's session, starting one when there is none, and
+// makes it available to the handler through currentSession
+func session(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s *Session
+		if c, err := r.Cookie(sessionCookie); err == nil && len(c.Value) == 64 {
+			s = &Session{ID: c.Value}
+			if email, err := getSessionEmail(hashSessionID(s.ID)); err == nil {
+				s.Email = email
+			}
+		} else {
+			s = newSession(w, r)
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+	})
+}
+
+// currentSession returns the session loaded by the session middleware
+func currentSession(r *http.Request) *Session {
+	s, _ := r.Context().Value(sessionKey{}).(*Session)
+	return s
+}
+
+// validCSRF returns whether a request carries the CSRF token of its session,
+// in the csrf field of a form or the X-CSRF-Token header
+func validCSRF(r *http.Request) bool {
+	s := currentSession(r)
+	if s == nil {
+		return false
+	}
+
+	token := r.Header.Get("X-CSRF-Token")
+	if len(token) == 0 {
+		token = r.FormValue("csrf")
+	}
+	return hmac.Equal([]byte(token), []byte(s.CSRF()))
+}
+
+// csrf refuses the requests changing state without the CSRF token of the
+// session, it goes after the session middleware
+func csrf(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" || r.Method == "HEAD" || validCSRF(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		log.Printf("invalid CSRF token on %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		d := &pageData{Title: "Une erreur est survenue"}
+		if err := render(w, r, "error.html", d); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// signIn rotates the session of the visitor and signs in an email, the
+// previous session id cannot be reused
+func signIn(w http.ResponseWriter, r *http.Request, email string) error {
+	if s := currentSession(r); s != nil {
+		if err := deleteSession(hashSessionID(s.ID)); err != nil {
+			log.Printf("unable to delete session: %s", err)
+		}
+	}
+
+	id := randomToken(32)
+	if err := insertSession(hashSessionID(id), email, time.Now().Add(sessionDuration)); err != nil {
+		return err
+	}
+	setSessionCookie(w, r, id, sessionDuration)
+	return nil
+}
+
+// signOut ends the session of the visitor and starts an anonymous one
+func signOut(w http.ResponseWriter, r *http.Request) error {
+	if s := currentSession(r); s != nil {
+		if err := deleteSession(hashSessionID(s.ID)); err != nil {
+			return err
+		}
+	}
+	newSession(w, r)
+	return nil
+}
+
+// expireSessions deletes the expired sessions, it is a scheduled job
+func expireSessions() {
+	if err := deleteExpiredSessions(); err != nil {
+		log.Println("unable to delete expired sessions: " + err.Error())
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if err := signOut(w, r); err != nil {
+		log.Printf("error on logoutHandler: %s", err)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// withSession returns the request with a session as loaded by the session
+// middleware
+func withSession(r *http.Request, s *Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+}
+
+func postForm(values url.Values) *http.Request {
+	r := httptest.NewRequest("POST", "/cart/add", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestValidCSRF(t *testing.T) {
+	s := &Session{ID: strings.Repeat("a", 64)}
+	other := &Session{ID: strings.Repeat("b", 64)}
+	if s.CSRF() == other.CSRF() {
+		t.Fatal("two sessions have the same CSRF token")
+	}
+
+	if !validCSRF(withSession(postForm(url.Values{"csrf": {s.CSRF()}}), s)) {
+		t.Error("the token of the session in the form is refused")
+	}
+
+	r := withSession(postForm(nil), s)
+	r.Header.Set("X-CSRF-Token", s.CSRF())
+	if !validCSRF(r) {
+		t.Error("the token of the session in the header is refused")
+	}
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{"no token", withSession(postForm(nil), s)},
+		{"empty token", withSession(postForm(url.Values{"csrf": {""}}), s)},
+		{"token of another session", withSession(postForm(url.Values{"csrf": {other.CSRF()}}), s)},
+		{"no session", postForm(url.Values{"csrf": {s.CSRF()}})},
+	}
+	for _, tt := range tests {
+		if validCSRF(tt.r) {
+			t.Errorf("%s: the request is accepted", tt.name)
+		}
+	}
+}
+
+func TestCSRFMiddlewarePassesThrough(t *testing.T) {
+	s := &Session{ID: strings.Repeat("a", 64)}
+	called := false
+	h := csrf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	for _, r := range []*http.Request{
+		withSession(httptest.NewRequest("GET", "/cart", nil), s),
+		withSession(httptest.NewRequest("HEAD", "/cart", nil), s),
+		withSession(postForm(url.Values{"csrf": {s.CSRF()}}), s),
+	} {
+		called = false
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if !called {
+			t.Errorf("%s %s with a valid token did not reach the handler", r.Method, r.URL.Path)
+		}
+	}
+}
+
+func TestSessionCookieSecure(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1")
+
+	secure := func(r *http.Request) bool {
+		w := httptest.NewRecorder()
+		newSession(w, r)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("got %d cookies, want the session cookie", len(cookies))
+		}
+		return cookies[0].Secure
+	}
+
+	r := httptest.NewRequest("GET", "http://localhost:8081/", nil)
+	if secure(r) {
+		t.Error("the cookie of a plain HTTP request is only sent over HTTPS")
+	}
+
+	if !secure(httptest.NewRequest("GET", "https://focuscentric.com/", nil)) {
+		t.Error("the cookie of an HTTPS request is not Secure")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if !secure(r) {
+		t.Error("the cookie of a request forwarded over HTTPS by our proxy is not Secure")
+	}
+
+	r.RemoteAddr = "198.51.100.9:5000"
+	if secure(r) {
+		t.Error("X-Forwarded-Proto is trusted from a visitor")
+	}
+}
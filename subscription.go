@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// subscriptionPlan is an all-access plan billed by the payment provider
+type subscriptionPlan struct {
+	Code         string
+	Name         string
+	Interval     string
+	Price        Money
+	ProviderPlan string
+}
+
+var subscriptionPlans = []*subscriptionPlan{
+	{Code: "monthly", Name: "Accès illimité mensuel", Interval: "mois", Price: cad(2900), ProviderPlan: planID("STRIPE_PLAN_MONTHLY", "all-access-monthly")},
+	{Code: "yearly", Name: "Accès illimité annuel", Interval: "an", Price: cad(29000), ProviderPlan: planID("STRIPE_PLAN_YEARLY", "all-access-yearly")},
+}
+
+func planID(env, def string) string {
+	if id := os.Getenv(env); len(id) > 0 {
+		return id
+	}
+	return def
+}
+
+func findPlan(code string) *subscriptionPlan {
+	for _, p := range subscriptionPlans {
+		if p.Code == code {
+			return p
+		}
+	}
+	return nil
+}
+
+const accessCookie = "access"
+
+// IsActive returns true while the subscription unlocks the paid productions,
+// a failed renewal (past_due) keeps the access while the provider retries
+func (s *Subscription) IsActive() bool {
+	switch s.Status {
+	case "active", "trialing", "past_due":
+		return s.PeriodEnd.After(time.Now())
+	}
+	return false
+}
+
+// subscriptionPurpose signs the links managing a subscription
+const subscriptionPurpose = "subscription"
+
+// Token returns the signed key of the subscription page links, it cannot be
+// made up from the subscription ID
+func (s *Subscription) Token() string {
+	return signedToken(subscriptionPurpose, s.Email+"|"+s.SubscriptionID)
+}
+
+// accessToken returns the key of the access cookie identifying the subscriber
+func (s *Subscription) accessToken() string {
+	return purchaseToken(s.Email, 0, s.SubscriptionID)
+}
+
+// parseSubscriptionToken returns the email and subscription ID of a token
+// made by Token
+func parseSubscriptionToken(token string) (email, subscriptionID string, err error) {
+	value, err := parseSignedToken(subscriptionPurpose, token)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(value, "|", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("invalid subscription token")
+	}
+	return parts[0], parts[1], nil
+}
+
+// activeSubscription returns the active subscription of an email, if any
+func activeSubscription(email string) *Subscription {
+	subs, err := GetSubscriptions(email)
+	if err != nil {
+		log.Println("unable to get subscriptions: " + err.Error())
+		return nil
+	}
+
+	for _, s := range subs {
+		if s.IsActive() {
+			return s
+		}
+	}
+	return nil
+}
+
+// hasAccess returns true if the email bought the production or has an active
+// subscription
+func hasAccess(email string, productionID int) bool {
+	if len(email) == 0 {
+		return false
+	}
+
+	ok, err := hasPurchased(email, productionID)
+	if err != nil {
+		log.Println("unable to check purchases: " + err.Error())
+	}
+	return ok || activeSubscription(email) != nil
+}
+
+// recordDownload counts a download made with a token and returns false when
+// the token does not give access to the production. Buyers download with their
+// charge id, teammates with their seat token, gift recipients with their gift
+// token and subscribers with their subscription id.
+func recordDownload(email string, productionID int, ref string) bool {
+	if err := increaseDownload(email, productionID, ref); err == nil {
+		return true
+	}
+	if err := increaseSeatDownload(email, productionID, ref); err == nil {
+		return true
+	}
+	if err := increaseGiftDownload(email, productionID, ref); err == nil {
+		return true
+	}
+
+	s, err := GetSubscription(email, ref)
+	return err == nil && s.IsActive()
+}
+
+// setAccessCookie remembers the visitor's purchase or subscription token so
+// paid episodes can be watched
+func setAccessCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+	})
+}
+
+// visitorEmail returns the email of the visitor identified by the access
+// cookie, the token must match a purchase, a license seat, a gift or a
+// subscription of that email
+func visitorEmail(r *http.Request) string {
+	c, err := r.Cookie(accessCookie)
+	if err != nil {
+		return ""
+	}
+
+	email, prodID, ref, err := parsePurchaseToken(c.Value)
+	if err != nil {
+		return ""
+	}
+
+	if prodID == 0 {
+		if _, err := GetSubscription(email, ref); err != nil {
+			return ""
+		}
+	} else if _, err := GetPurchase(email, prodID, ref); err != nil {
+		if _, err := GetSeat(email, prodID, ref); err != nil {
+			if _, err := GetGift(email, prodID, ref); err != nil {
+				return ""
+			}
+		}
+	}
+	return email
+}
+
+func sendSubscriptionConfirmation(s *Subscription, plan *subscriptionPlan) {
+	emailData := subscriptionEmail{
+		Name:  s.Email,
+		Plan:  plan.Name,
+		Price: plan.Price.String() + " / " + plan.Interval,
+		Token: s.Token(),
+	}
+
+	if err := sendEmail(s.Email, emailData); err != nil {
+		log.Println("unable to send subscription confirmation: " + err.Error())
+	}
+}
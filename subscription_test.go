@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionToken(t *testing.T) {
+	s := &Subscription{Email: "marie@example.com", SubscriptionID: "sub_123"}
+
+	email, id, err := parseSubscriptionToken(s.Token())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != s.Email || id != s.SubscriptionID {
+		t.Errorf("parseSubscriptionToken() = %q, %q, want %q, %q", email, id, s.Email, s.SubscriptionID)
+	}
+}
+
+func TestSubscriptionTokenTampered(t *testing.T) {
+	s := &Subscription{Email: "marie@example.com", SubscriptionID: "sub_123"}
+	other := &Subscription{Email: "jean@example.com", SubscriptionID: "sub_456"}
+
+	// the value of another subscription with the signature of ours
+	value := strings.Split(other.Token(), ".")[0]
+	sig := strings.Split(s.Token(), ".")[1]
+
+	tokens := []string{
+		value + "." + sig,
+		s.accessToken(),
+		purchaseToken(other.Email, 0, other.SubscriptionID),
+		signedToken("unsubscribe", other.Email+"|"+other.SubscriptionID),
+		signedToken(subscriptionPurpose, "sans séparateur"),
+	}
+	for _, token := range tokens {
+		if email, id, err := parseSubscriptionToken(token); err == nil {
+			t.Errorf("parseSubscriptionToken(%q) = %q, %q, want an error", token, email, id)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"strings"
+)
+
+// taxRate holds the sales tax rates of a province. Rates are expressed in
+// thousandths of a percent so the QST (9.975%) can be represented exactly.
+type taxRate struct {
+	GST int
+	HST int
+	PST int
+	QST int
+}
+
+// Taxes represents the sales taxes charged on a purchase, amounts are in cents
+type Taxes struct {
+	Country  string `json:"country"`
+	Province string `json:"province"`
+	GST      int    `json:"gst"`
+	HST      int    `json:"hst"`
+	PST      int    `json:"pst"`
+	QST      int    `json:"qst"`
+}
+
+// TaxLine is a single named tax used to display a receipt
+type TaxLine struct {
+	Name     string
+	Rate     string
+	Amount   int
+	Currency string
+}
+
+// business home province, used when a Canadian buyer's province is unknown
+const homeProvince = "QC"
+
+var provinceTaxRates = map[string]taxRate{
+	"AB": {GST: 5000},
+	"BC": {GST: 5000, PST: 7000},
+	"MB": {GST: 5000, PST: 7000},
+	"NB": {HST: 15000},
+	"NL": {HST: 15000},
+	"NS": {HST: 14000},
+	"NT": {GST: 5000},
+	"NU": {GST: 5000},
+	"ON": {HST: 13000},
+	"PE": {HST: 15000},
+	"QC": {GST: 5000, QST: 9975},
+	"SK": {GST: 5000, PST: 6000},
+	"YT": {GST: 5000},
+}
+
+var provinceNames = map[string]string{
+	"ALBERTA":                   "AB",
+	"BRITISH COLUMBIA":          "BC",
+	"COLOMBIE-BRITANNIQUE":      "BC",
+	"MANITOBA":                  "MB",
+	"NEW BRUNSWICK":             "NB",
+	"NOUVEAU-BRUNSWICK":         "NB",
+	"NEWFOUNDLAND AND LABRADOR": "NL",
+	"TERRE-NEUVE-ET-LABRADOR":   "NL",
+	"NOVA SCOTIA":               "NS",
+	"NOUVELLE-ECOSSE":           "NS",
+	"NOUVELLE-ÉCOSSE":           "NS",
+	"NORTHWEST TERRITORIES":     "NT",
+	"TERRITOIRES DU NORD-OUEST": "NT",
+	"NUNAVUT":                   "NU",
+	"ONTARIO":                   "ON",
+	"PRINCE EDWARD ISLAND":      "PE",
+	"ILE-DU-PRINCE-EDOUARD":     "PE",
+	"ÎLE-DU-PRINCE-ÉDOUARD":     "PE",
+	"QUEBEC":                    "QC",
+	"QUÉBEC":                    "QC",
+	"SASKATCHEWAN":              "SK",
+	"YUKON":                     "YT",
+}
+
+// normalizeProvince returns the two letters province code from either a code
+// or a province name as entered by the buyer
+func normalizeProvince(s string) string {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if _, ok := provinceTaxRates[s]; ok {
+		return s
+	}
+	if code, ok := provinceNames[s]; ok {
+		return code
+	}
+	return ""
+}
+
+// computeTaxes returns the sales taxes for a subtotal in cents. Buyers outside
+// of Canada are not charged any taxes.
+func computeTaxes(country, province string, subtotal int) Taxes {
+	t := Taxes{Country: strings.ToUpper(strings.TrimSpace(country)), Province: normalizeProvince(province)}
+	if t.Country != "CA" {
+		t.Province = ""
+		return t
+	}
+
+	if len(t.Province) == 0 {
+		t.Province = homeProvince
+	}
+
+	rate := provinceTaxRates[t.Province]
+	t.GST = applyRate(subtotal, rate.GST)
+	t.HST = applyRate(subtotal, rate.HST)
+	t.PST = applyRate(subtotal, rate.PST)
+	t.QST = applyRate(subtotal, rate.QST)
+	return t
+}
+
+// taxPercent returns the combined sales tax rate of a buyer, i.e. 14.975 in Quebec,
+// used by the payment provider to tax recurring payments
+func taxPercent(country, province string) float64 {
+	t := computeTaxes(country, province, 0)
+	if t.Country != "CA" {
+		return 0
+	}
+
+	rate := provinceTaxRates[t.Province]
+	return float64(rate.GST+rate.HST+rate.PST+rate.QST) / 1000
+}
+
+// applyRate rounds the tax to the nearest cent
+func applyRate(amount, rate int) int {
+	return (amount*rate + 50000) / 100000
+}
+
+// estimatedTaxes returns the taxes a buyer of our home province pays on a
+// price in Canadian dollars. The payment form shows them before the billing
+// address is entered, the other currencies are for buyers outside of Canada
+func estimatedTaxes(price Money) Taxes {
+	if price.Currency != defaultCurrency {
+		return Taxes{}
+	}
+	return computeTaxes("CA", homeProvince, price.Amount)
+}
+
+// withEstimatedTaxes returns the price with its estimated taxes, the amount
+// shown by the payment form. The buyer is charged the taxes of their billing
+// province, which may be more
+func withEstimatedTaxes(price Money) Money {
+	return price.Add(estimatedTaxes(price).Total())
+}
+
+// maxTaxRate returns the highest combined sales tax rate of a Canadian
+// province, i.e. "15 %", shown next to the estimated taxes
+func maxTaxRate() string {
+	max := 0
+	for _, rate := range provinceTaxRates {
+		if r := rate.GST + rate.HST + rate.PST + rate.QST; r > max {
+			max = r
+		}
+	}
+	return formatRate(max)
+}
+
+// Total returns the sum of all taxes in cents
+func (t Taxes) Total() int {
+	return t.GST + t.HST + t.PST + t.QST
+}
+
+// Lines returns the non-zero taxes with their French receipt label
+func (t Taxes) Lines(currency string) []TaxLine {
+	rate := provinceTaxRates[t.Province]
+
+	var lines []TaxLine
+	add := func(name string, r, amount int) {
+		if amount > 0 {
+			lines = append(lines, TaxLine{Name: name, Rate: formatRate(r), Amount: amount, Currency: currency})
+		}
+	}
+	add("TPS", rate.GST, t.GST)
+	add("TVH", rate.HST, t.HST)
+	add("TVP", rate.PST, t.PST)
+	add("TVQ", rate.QST, t.QST)
+	return lines
+}
+
+// Display returns the formatted tax amount
+func (l TaxLine) Display() string {
+	return formatMoney(l.Amount, l.Currency)
+}
+
+func formatRate(r int) string {
+	s := strings.TrimRight(strings.TrimRight(formatDecimal(r, 3), "0"), ".")
+	return strings.Replace(s, ".", ",", 1) + " %"
+}
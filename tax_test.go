@@ -0,0 +1,104 @@
+package main
+
+import "testing"
+
+func TestApplyRate(t *testing.T) {
+	tests := []struct {
+		amount, rate, want int
+	}{
+		{1000, 5000, 50},
+		{1000, 9975, 100},
+		{4900, 9975, 489},
+		{4900, 13000, 637},
+		{10, 5000, 1},
+		{9, 5000, 0},
+		{0, 9975, 0},
+		{1000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := applyRate(tt.amount, tt.rate); got != tt.want {
+			t.Errorf("applyRate(%d, %d) = %d, want %d", tt.amount, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestComputeTaxes(t *testing.T) {
+	tests := []struct {
+		country, province string
+		subtotal          int
+		want              Taxes
+	}{
+		{"CA", "QC", 4900, Taxes{Country: "CA", Province: "QC", GST: 245, QST: 489}},
+		{"ca", " Québec ", 4900, Taxes{Country: "CA", Province: "QC", GST: 245, QST: 489}},
+		{"CA", "ON", 4900, Taxes{Country: "CA", Province: "ON", HST: 637}},
+		{"CA", "British Columbia", 4900, Taxes{Country: "CA", Province: "BC", GST: 245, PST: 343}},
+		{"CA", "AB", 4900, Taxes{Country: "CA", Province: "AB", GST: 245}},
+		{"CA", "", 4900, Taxes{Country: "CA", Province: homeProvince, GST: 245, QST: 489}},
+		{"CA", "Atlantis", 4900, Taxes{Country: "CA", Province: homeProvince, GST: 245, QST: 489}},
+		{"US", "NY", 4900, Taxes{Country: "US"}},
+		{"FR", "QC", 4900, Taxes{Country: "FR"}},
+		{"", "", 4900, Taxes{}},
+	}
+	for _, tt := range tests {
+		if got := computeTaxes(tt.country, tt.province, tt.subtotal); got != tt.want {
+			t.Errorf("computeTaxes(%q, %q, %d) = %+v, want %+v", tt.country, tt.province, tt.subtotal, got, tt.want)
+		}
+	}
+}
+
+func TestTaxesTotalAndLines(t *testing.T) {
+	taxes := computeTaxes("CA", "QC", 4900)
+	if got := taxes.Total(); got != 734 {
+		t.Errorf("Total() = %d, want 734", got)
+	}
+
+	lines := taxes.Lines(defaultCurrency)
+	if len(lines) != 2 {
+		t.Fatalf("Lines() = %+v, want TPS and TVQ", lines)
+	}
+	if lines[0].Name != "TPS" || lines[0].Amount != 245 || lines[1].Name != "TVQ" || lines[1].Amount != 489 {
+		t.Errorf("Lines() = %+v, want TPS 245 and TVQ 489", lines)
+	}
+
+	if lines := computeTaxes("US", "", 4900).Lines("USD"); len(lines) != 0 {
+		t.Errorf("Lines() outside of Canada = %+v, want none", lines)
+	}
+}
+
+func TestTaxPercent(t *testing.T) {
+	tests := []struct {
+		country, province string
+		want              float64
+	}{
+		{"CA", "QC", 14.975},
+		{"CA", "ON", 13},
+		{"CA", "", 14.975},
+		{"US", "CA", 0},
+	}
+	for _, tt := range tests {
+		if got := taxPercent(tt.country, tt.province); got != tt.want {
+			t.Errorf("taxPercent(%q, %q) = %v, want %v", tt.country, tt.province, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatedTaxes(t *testing.T) {
+	price := Money{Amount: 4900, Currency: defaultCurrency}
+	if got := withEstimatedTaxes(price); got != (Money{Amount: 5634, Currency: defaultCurrency}) {
+		t.Errorf("withEstimatedTaxes(%v) = %v, want 5634 %s", price, got, defaultCurrency)
+	}
+
+	usd := Money{Amount: 4900, Currency: "USD"}
+	if got := estimatedTaxes(usd); got.Total() != 0 {
+		t.Errorf("estimatedTaxes(%v) = %+v, want none", usd, got)
+	}
+	if got := withEstimatedTaxes(usd); got != usd {
+		t.Errorf("withEstimatedTaxes(%v) = %v, want the price unchanged", usd, got)
+	}
+}
+
+func TestMaxTaxRate(t *testing.T) {
+	if got := maxTaxRate(); got != "15 %" {
+		t.Errorf("maxTaxRate() = %q, want the HST of the Atlantic provinces, 15 %%", got)
+	}
+}
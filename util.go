@@ -2,13 +2,21 @@ package main
 
 import (
 	"bytes"
-	"fmt"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"html"
 	"html/template"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/mailgun/mailgun-go"
+	"sync"
 )
 
 func stripHTML(s string) string {
@@ -75,13 +83,158 @@ func stripHTML(s string) string {
 	return output
 }
 
-func sendMail(to, subject, body string) {
-	text := stripHTML(body)
-	gun := mailgun.NewMailgun("mg.focuscentric.com", os.Getenv("MG_KEY"), os.Getenv("MG_PUBKEY"))
-	m := mailgun.NewMessage("Dominic de Focus Centric <dominic@focuscentric.com>", subject, text, "<"+to+">")
-	m.SetHtml(body)
-	_, _, err := gun.Send(m)
+// trustedProxies returns the networks of TRUSTED_PROXIES, a comma separated
+// list of the IP addresses or CIDR ranges of the proxies in front of us
+func trustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			networks = append(networks, n)
+		} else {
+			log.Printf("invalid trusted proxy %q: %s", s, err)
+		}
+	}
+	return networks
+}
+
+// isTrustedProxy returns whether an address is one of our proxies
+func isTrustedProxy(ip net.IP, proxies []*net.IPNet) bool {
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the host of the connection, without its port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		fmt.Println("error sending email: " + err.Error())
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// fromTrustedProxy returns whether the request was forwarded by one of our
+// proxies, only then can its X-Forwarded headers be believed
+func fromTrustedProxy(r *http.Request) bool {
+	ip := net.ParseIP(remoteHost(r))
+	return ip != nil && isTrustedProxy(ip, trustedProxies())
+}
+
+// clientIP returns the IP of the visitor. Behind our proxies it is the last
+// address of X-Forwarded-For they did not append themselves, the addresses
+// before it are sent by the client and can be forged. Otherwise the header
+// is ignored and the connection address is used
+func clientIP(r *http.Request) string {
+	host := remoteHost(r)
+	if ip := net.ParseIP(host); ip != nil {
+		if proxies := trustedProxies(); isTrustedProxy(ip, proxies) {
+			if fwd := r.Header["X-Forwarded-For"]; len(fwd) > 0 {
+				hops := strings.Split(fwd[len(fwd)-1], ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop := net.ParseIP(strings.TrimSpace(hops[i]))
+					if hop == nil {
+						break
+					}
+					if !isTrustedProxy(hop, proxies) || i == 0 {
+						return hop.String()
+					}
+				}
+			}
+		}
+	}
+	if len(host) > 50 {
+		host = host[:50]
+	}
+	return host
+}
+
+// randomToken returns a random hex encoded string of n bytes
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+var (
+	secretOnce sync.Once
+	secret     []byte
+)
+
+// tokenSecret returns TOKEN_SECRET, the key signing our tokens, the server
+// does not start without it since the links already sent must stay valid
+func tokenSecret() []byte {
+	secretOnce.Do(func() {
+		secret = []byte(os.Getenv("TOKEN_SECRET"))
+		if len(secret) == 0 {
+			log.Fatal("TOKEN_SECRET is not set")
+		}
+	})
+	return secret
+}
+
+func tokenSignature(purpose, value string) []byte {
+	mac := hmac.New(sha256.New, tokenSecret())
+	mac.Write([]byte(purpose + "|" + value))
+	return mac.Sum(nil)
+}
+
+// signedToken returns value and its signature for a purpose, so a token
+// signed for one purpose cannot be used for another
+func signedToken(purpose, value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(value)) + "." +
+		base64.RawURLEncoding.EncodeToString(tokenSignature(purpose, value))
+}
+
+// parseSignedToken returns the value of a token signed for the purpose
+func parseSignedToken(purpose, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errors.New("invalid token")
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New("invalid token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, tokenSignature(purpose, string(value))) {
+		return "", errors.New("invalid token signature")
+	}
+	return string(value), nil
+}
+
+// formatDecimal formats an integer holding a fixed number of decimals, i.e.
+// formatDecimal(1999, 2) returns 19.99
+func formatDecimal(v, decimals int) string {
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	s := strconv.Itoa(v)
+	for len(s) <= decimals {
+		s = "0" + s
+	}
+
+	if decimals > 0 {
+		s = s[:len(s)-decimals] + "." + s[len(s)-decimals:]
 	}
+	return sign + s
 }
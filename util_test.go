@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// the tokens are signed with a fixed key rather than TOKEN_SECRET
+	secretOnce.Do(func() { secret = []byte("test secret") })
+}
+
+func TestSignedTokenRoundTrip(t *testing.T) {
+	for _, value := range []string{"42", "client@example.com", "12|1700000000", ""} {
+		token := signedToken("unsubscribe", value)
+		got, err := parseSignedToken("unsubscribe", token)
+		if err != nil {
+			t.Errorf("parseSignedToken(%q) error: %s", token, err)
+		} else if got != value {
+			t.Errorf("parseSignedToken(%q) = %q, want %q", token, got, value)
+		}
+	}
+}
+
+func TestParseSignedTokenWrongPurpose(t *testing.T) {
+	token := signedToken("unsubscribe", "42")
+	if _, err := parseSignedToken("login", token); err == nil {
+		t.Error("expected an error for a token signed for another purpose")
+	}
+}
+
+func TestParseSignedTokenTampered(t *testing.T) {
+	token := signedToken("login", "42")
+	parts := strings.Split(token, ".")
+
+	value := base64.RawURLEncoding.EncodeToString([]byte("43"))
+	if _, err := parseSignedToken("login", value+"."+parts[1]); err == nil {
+		t.Error("expected an error for a changed value")
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	sig[0] ^= 1
+	if _, err := parseSignedToken("login", parts[0]+"."+base64.RawURLEncoding.EncodeToString(sig)); err == nil {
+		t.Error("expected an error for a changed signature")
+	}
+}
+
+func TestParseSignedTokenMalformed(t *testing.T) {
+	for _, token := range []string{"", "42", "a.b.c", "!!.abc", "NDI.!!", "NDI."} {
+		if _, err := parseSignedToken("login", token); err == nil {
+			t.Errorf("parseSignedToken(%q) expected an error", token)
+		}
+	}
+}